@@ -156,6 +156,11 @@ func (d *DistributedDriver) DeleteAll(collection string) error {
 }
 
 func (d *DistributedDriver) Search(query map[string]interface{}) (map[string][]string, error) {
+	match, err := compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
 	results := make(map[string][]string)
 	for _, node := range d.nodes {
 		err := filepath.Walk(filepath.Join(d.dir, node), func(path string, info os.FileInfo, err error) error {
@@ -174,7 +179,7 @@ func (d *DistributedDriver) Search(query map[string]interface{}) (map[string][]s
 					return err
 				}
 
-				if matchesQuery(record, query) {
+				if match(record) {
 					collection := filepath.Base(filepath.Dir(path))
 					resource := filepath.Base(path)
 					resource = resource[:len(resource)-len(filepath.Ext(resource))]
@@ -190,58 +195,6 @@ func (d *DistributedDriver) Search(query map[string]interface{}) (map[string][]s
 	return results, nil
 }
 
-func matchesQuery(record, query map[string]interface{}) bool {
-	for key, value := range query {
-		if recordValue, ok := record[key]; ok {
-			switch value := value.(type) {
-			case map[string]interface{}: // Handling nested queries like { "age": { "$gt": 25 } }
-				for op, v := range value {
-					switch op {
-					case "$gt":
-						if recordValue.(float64) <= v.(float64) {
-							return false
-						}
-					case "$lt":
-						if recordValue.(float64) >= v.(float64) {
-							return false
-						}
-					case "$gte":
-						if recordValue.(float64) < v.(float64) {
-							return false
-						}
-					case "$lte":
-						if recordValue.(float64) > v.(float64) {
-							return false
-						}
-					case "$ne":
-						if recordValue == v {
-							return false
-						}
-					case "$in":
-						found := false
-						for _, item := range v.([]interface{}) {
-							if recordValue == item {
-								found = true
-								break
-							}
-						}
-						if !found {
-							return false
-						}
-					}
-				}
-			default:
-				if recordValue != value {
-					return false
-				}
-			}
-		} else {
-			return false
-		}
-	}
-	return true
-}
-
 func (d *DistributedDriver) RegexSearch(collection string, query map[string]string) ([]map[string]interface{}, error) {
 	var records []map[string]interface{}
 	for _, node := range d.nodes {
@@ -271,13 +224,14 @@ func (d *DistributedDriver) RegexSearch(collection string, query map[string]stri
 
 func matchesRegex(record map[string]interface{}, query map[string]string) bool {
 	for key, pattern := range query {
-		if value, ok := record[key]; ok {
-			strValue := fmt.Sprintf("%v", value)
-			matched, err := regexp.MatchString(pattern, strValue)
-			if err != nil || !matched {
-				return false
-			}
-		} else {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false
+		}
+		matched := anyValue(fieldValues(record, key), func(v interface{}) bool {
+			return re.MatchString(fmt.Sprintf("%v", v))
+		})
+		if !matched {
 			return false
 		}
 	}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fieldValues returns every value reachable through a dot-separated path
+// the way queries see it. A numeric part such as the 0 in "items.0.sku"
+// selects an array element; any other part applied to an array is looked
+// up in each object element, so "items.sku" yields the sku of every item.
+// The result is empty if the path does not exist.
+func fieldValues(doc map[string]interface{}, path string) []interface{} {
+	return collectValues(doc, strings.Split(path, "."), nil)
+}
+
+func collectValues(cur interface{}, parts []string, out []interface{}) []interface{} {
+	if len(parts) == 0 {
+		return append(out, cur)
+	}
+
+	switch v := cur.(type) {
+	case map[string]interface{}:
+		if next, ok := v[parts[0]]; ok {
+			out = collectValues(next, parts[1:], out)
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(v) {
+				out = collectValues(v[i], parts[1:], out)
+			}
+			return out
+		}
+		for _, elem := range v {
+			if m, ok := elem.(map[string]interface{}); ok {
+				out = collectValues(m, parts, out)
+			}
+		}
+	}
+	return out
+}
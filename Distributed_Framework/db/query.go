@@ -0,0 +1,552 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidQuery is returned for malformed queries, for example ones that
+// use an unknown operator.
+var ErrInvalidQuery = errors.New("invalid query")
+
+func invalidQuery(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+// matcher reports whether a record satisfies a compiled query.
+type matcher func(record map[string]interface{}) bool
+
+// valueMatcher reports whether a field satisfies a condition, given the
+// values found at its path. values is empty when the record does not have
+// the field.
+type valueMatcher func(values []interface{}) bool
+
+// matchesQuery reports whether record satisfies query.
+func matchesQuery(record, query map[string]interface{}) (bool, error) {
+	m, err := compileQuery(query)
+	if err != nil {
+		return false, err
+	}
+	return m(record), nil
+}
+
+// compileQuery validates query and turns it into a matcher, so that a scan
+// checks the query once rather than once per record. Top-level entries
+// are combined with an implicit AND; $and, $or and $nor take an array of
+// queries and may be nested to any depth.
+func compileQuery(query map[string]interface{}) (matcher, error) {
+	parts := make([]matcher, 0, len(query))
+	for key, cond := range query {
+		var (
+			m   matcher
+			err error
+		)
+		switch key {
+		case "$and", "$or", "$nor":
+			m, err = compileLogical(key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, invalidQuery("unknown top-level operator %s", key)
+			}
+			m, err = compileField(key, cond)
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, m)
+	}
+	return allOf(parts), nil
+}
+
+func allOf(parts []matcher) matcher {
+	return func(record map[string]interface{}) bool {
+		for _, m := range parts {
+			if !m(record) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func compileLogical(op string, cond interface{}) (matcher, error) {
+	clauses, ok := cond.([]interface{})
+	if !ok || len(clauses) == 0 {
+		return nil, invalidQuery("%s expects a non-empty array of queries", op)
+	}
+
+	parts := make([]matcher, len(clauses))
+	for i, clause := range clauses {
+		q, ok := clause.(map[string]interface{})
+		if !ok {
+			return nil, invalidQuery("%s expects a non-empty array of queries", op)
+		}
+		m, err := compileQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		parts[i] = m
+	}
+
+	if op == "$and" {
+		return allOf(parts), nil
+	}
+	wantAny := op == "$or"
+	return func(record map[string]interface{}) bool {
+		for _, m := range parts {
+			if m(record) {
+				return wantAny
+			}
+		}
+		return !wantAny
+	}, nil
+}
+
+func compileField(key string, cond interface{}) (matcher, error) {
+	vm, err := compileCondition(cond)
+	if err != nil {
+		return nil, fmt.Errorf("%w (field %q)", err, key)
+	}
+	return func(record map[string]interface{}) bool {
+		return vm(fieldValues(record, key))
+	}, nil
+}
+
+// anyValue reports whether pred holds for one of values or, for a value
+// that is an array, for one of its elements. This is what lets
+// {"tags": "x"} match a record whose tags array contains "x".
+func anyValue(values []interface{}, pred func(v interface{}) bool) bool {
+	for _, v := range values {
+		if pred(v) {
+			return true
+		}
+		if arr, ok := v.([]interface{}); ok {
+			for _, elem := range arr {
+				if pred(elem) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func equalTo(arg interface{}) func(v interface{}) bool {
+	return func(v interface{}) bool {
+		return valuesEqual(coerceTo(v, arg), arg)
+	}
+}
+
+// compileCondition compiles the condition on a single field: an operator
+// expression such as {"$gt": 25}, or a literal value the field must equal.
+func compileCondition(cond interface{}) (valueMatcher, error) {
+	ops, ok := cond.(map[string]interface{})
+	if !ok || !isOperatorExpression(ops) {
+		if err := checkOperand(cond); err != nil {
+			return nil, err
+		}
+		return func(values []interface{}) bool {
+			return anyValue(values, equalTo(cond))
+		}, nil
+	}
+
+	parts := make([]valueMatcher, 0, len(ops))
+	for op, arg := range ops {
+		var (
+			vm  valueMatcher
+			err error
+		)
+		switch op {
+		case "$regex":
+			vm, err = compileRegex(arg, ops["$options"])
+		case "$options":
+			if _, ok := ops["$regex"]; !ok {
+				return nil, invalidQuery("$options requires $regex")
+			}
+			continue
+		default:
+			vm, err = compileOperator(op, arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, vm)
+	}
+	return func(values []interface{}) bool {
+		for _, vm := range parts {
+			if !vm(values) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// isOperatorExpression reports whether a condition object holds operators
+// rather than a literal sub-document or date to compare against.
+func isOperatorExpression(cond map[string]interface{}) bool {
+	if isDateLiteral(cond) {
+		return false
+	}
+	for key := range cond {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func isDateLiteral(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	_, ok = m["$date"]
+	return ok
+}
+
+// checkOperand rejects {"$date": ...} values that do not hold a date.
+func checkOperand(v interface{}) error {
+	if _, ok := dateValue(v); isDateLiteral(v) && !ok {
+		return invalidQuery("$date expects an RFC 3339 timestamp or milliseconds since the epoch")
+	}
+	return nil
+}
+
+// coerceTo lets a string field be compared with a date operand, since
+// time.Time values written through the driver are stored as RFC 3339
+// strings.
+func coerceTo(v, operand interface{}) interface{} {
+	if _, ok := dateValue(operand); !ok {
+		return v
+	}
+	if s, ok := v.(string); ok {
+		if t, err := parseDate(s); err == nil {
+			return t
+		}
+	}
+	return v
+}
+
+// compareTo returns a predicate that orders a value against operand with
+// cmp. Only values of the operand's type match, so {"$gt": 5} never
+// matches a string and {"$lt": "m"} never matches a number.
+func compareTo(operand interface{}, cmp func(c int) bool) func(v interface{}) bool {
+	rank := typeRank(operand)
+	return func(v interface{}) bool {
+		v = coerceTo(v, operand)
+		return typeRank(v) == rank && cmp(orderValues(v, operand))
+	}
+}
+
+func compileOperator(op string, arg interface{}) (valueMatcher, error) {
+	switch op {
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		if err := checkOperand(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op {
+	case "$eq":
+		return func(values []interface{}) bool {
+			return anyValue(values, equalTo(arg))
+		}, nil
+
+	case "$ne":
+		return func(values []interface{}) bool {
+			return !anyValue(values, equalTo(arg))
+		}, nil
+
+	case "$gt", "$gte", "$lt", "$lte":
+		cmp := map[string]func(c int) bool{
+			"$gt":  func(c int) bool { return c > 0 },
+			"$gte": func(c int) bool { return c >= 0 },
+			"$lt":  func(c int) bool { return c < 0 },
+			"$lte": func(c int) bool { return c <= 0 },
+		}[op]
+		pred := compareTo(arg, cmp)
+		return func(values []interface{}) bool {
+			return anyValue(values, pred)
+		}, nil
+
+	case "$in":
+		items, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalidQuery("$in expects an array")
+		}
+		preds := make([]func(v interface{}) bool, len(items))
+		for i, item := range items {
+			if err := checkOperand(item); err != nil {
+				return nil, err
+			}
+			preds[i] = equalTo(item)
+		}
+		return func(values []interface{}) bool {
+			return anyValue(values, func(v interface{}) bool {
+				for _, pred := range preds {
+					if pred(v) {
+						return true
+					}
+				}
+				return false
+			})
+		}, nil
+
+	case "$nin":
+		items, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalidQuery("$nin expects an array")
+		}
+		in, err := compileOperator("$in", items)
+		if err != nil {
+			return nil, err
+		}
+		return func(values []interface{}) bool {
+			return !in(values)
+		}, nil
+
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return nil, invalidQuery("$exists expects true or false")
+		}
+		return func(values []interface{}) bool {
+			return (len(values) > 0) == want
+		}, nil
+
+	case "$all":
+		items, ok := arg.([]interface{})
+		if !ok {
+			return nil, invalidQuery("$all expects an array")
+		}
+		preds := make([]func(v interface{}) bool, len(items))
+		for i, item := range items {
+			if err := checkOperand(item); err != nil {
+				return nil, err
+			}
+			preds[i] = equalTo(item)
+		}
+		return func(values []interface{}) bool {
+			for _, pred := range preds {
+				if !anyValue(values, pred) {
+					return false
+				}
+			}
+			return len(preds) > 0
+		}, nil
+
+	case "$size":
+		n, ok := toFloat(arg)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return nil, invalidQuery("$size expects a non-negative integer")
+		}
+		return func(values []interface{}) bool {
+			for _, v := range values {
+				if arr, ok := v.([]interface{}); ok && len(arr) == int(n) {
+					return true
+				}
+			}
+			return false
+		}, nil
+
+	case "$type":
+		ranks, err := typeRanks(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(values []interface{}) bool {
+			return anyValue(values, func(v interface{}) bool {
+				return ranks[typeRank(v)]
+			})
+		}, nil
+
+	case "$mod":
+		args, ok := arg.([]interface{})
+		if !ok || len(args) != 2 {
+			return nil, invalidQuery("$mod expects [divisor, remainder]")
+		}
+		divisor, ok := toFloat(args[0])
+		remainder, ok2 := toFloat(args[1])
+		if !ok || !ok2 || math.Trunc(divisor) == 0 {
+			return nil, invalidQuery("$mod expects a non-zero divisor and a numeric remainder")
+		}
+		d, r := int64(divisor), int64(remainder)
+		return func(values []interface{}) bool {
+			return anyValue(values, func(v interface{}) bool {
+				x, ok := toFloat(v)
+				return ok && int64(x)%d == r
+			})
+		}, nil
+
+	case "$elemMatch":
+		return compileElemMatch(arg)
+
+	case "$not":
+		inner, ok := arg.(map[string]interface{})
+		if !ok || !isOperatorExpression(inner) {
+			return nil, invalidQuery("$not expects an operator expression")
+		}
+		vm, err := compileCondition(inner)
+		if err != nil {
+			return nil, err
+		}
+		return func(values []interface{}) bool {
+			return !vm(values)
+		}, nil
+	}
+
+	if !strings.HasPrefix(op, "$") {
+		return nil, invalidQuery("cannot mix operators with field %q in a condition", op)
+	}
+	return nil, invalidQuery("unknown operator %s", op)
+}
+
+// typeNames maps the names accepted by $type to type ranks. The numeric
+// BSON type codes are accepted as well.
+var typeNames = map[string]int{
+	"null":    1,
+	"number":  2,
+	"double":  2,
+	"int":     2,
+	"long":    2,
+	"decimal": 2,
+	"string":  3,
+	"object":  4,
+	"array":   5,
+	"bool":    6,
+	"date":    7,
+}
+
+var typeCodes = map[float64]int{
+	1:  2, // double
+	2:  3, // string
+	3:  4, // object
+	4:  5, // array
+	8:  6, // bool
+	9:  7, // date
+	10: 1, // null
+	16: 2, // int
+	18: 2, // long
+	19: 2, // decimal
+}
+
+// typeRanks resolves the operand of $type, a type name, a BSON type code
+// or an array of those, to a set of type ranks.
+func typeRanks(arg interface{}) (map[int]bool, error) {
+	items, ok := arg.([]interface{})
+	if !ok {
+		items = []interface{}{arg}
+	}
+
+	ranks := make(map[int]bool, len(items))
+	for _, item := range items {
+		var (
+			rank  int
+			found bool
+		)
+		if name, ok := item.(string); ok {
+			rank, found = typeNames[name]
+		} else if code, ok := toFloat(item); ok {
+			rank, found = typeCodes[code]
+		}
+		if !found {
+			return nil, invalidQuery("$type does not know the type %v", item)
+		}
+		ranks[rank] = true
+	}
+	return ranks, nil
+}
+
+// compileRegex compiles {"$regex": pattern, "$options": flags}. The options
+// i, m and s are supported; only string values can match.
+func compileRegex(pattern, options interface{}) (valueMatcher, error) {
+	expr, ok := pattern.(string)
+	if !ok {
+		return nil, invalidQuery("$regex expects a string")
+	}
+	if options != nil {
+		flags, ok := options.(string)
+		if !ok || strings.Trim(flags, "ims") != "" {
+			return nil, invalidQuery("$options may only contain the flags i, m and s")
+		}
+		if flags != "" {
+			expr = "(?" + flags + ")" + expr
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, invalidQuery("$regex: %v", err)
+	}
+	return func(values []interface{}) bool {
+		return anyValue(values, func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && re.MatchString(s)
+		})
+	}, nil
+}
+
+// compileElemMatch compiles $elemMatch, which matches an array field when a
+// single element satisfies every condition. The operand is either a query
+// on sub-document elements, {"sku": "s1", "qty": {"$gt": 2}}, or
+// conditions on the elements themselves, {"$gte": 80, "$lt": 85}.
+func compileElemMatch(arg interface{}) (valueMatcher, error) {
+	cond, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, invalidQuery("$elemMatch expects an object")
+	}
+
+	var elem func(v interface{}) bool
+	if isElementCondition(cond) {
+		vm, err := compileCondition(cond)
+		if err != nil {
+			return nil, err
+		}
+		elem = func(v interface{}) bool {
+			return vm([]interface{}{v})
+		}
+	} else {
+		m, err := compileQuery(cond)
+		if err != nil {
+			return nil, err
+		}
+		elem = func(v interface{}) bool {
+			doc, ok := v.(map[string]interface{})
+			return ok && m(doc)
+		}
+	}
+
+	return func(values []interface{}) bool {
+		for _, v := range values {
+			arr, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, item := range arr {
+				if elem(item) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// isElementCondition reports whether an $elemMatch operand applies
+// operators to the elements directly rather than being a query on them.
+func isElementCondition(cond map[string]interface{}) bool {
+	if !isOperatorExpression(cond) {
+		return false
+	}
+	for key := range cond {
+		switch key {
+		case "$and", "$or", "$nor":
+			return false
+		}
+	}
+	return true
+}
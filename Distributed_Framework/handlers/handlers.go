@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -97,6 +98,10 @@ func SearchDistributedResourcesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	results, err := distributedDatabase.Search(query)
+	if errors.Is(err, db.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,625 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// pipelineStage transforms the documents flowing through an aggregation.
+type pipelineStage func(docs []map[string]interface{}) []map[string]interface{}
+
+// Aggregate runs a MongoDB-style aggregation pipeline over collection and
+// returns the documents that come out of it. The stages $match, $group,
+// $project, $sort, $skip, $limit, $unwind, $count and $lookup are
+// supported; a pipeline using anything else fails with ErrInvalidQuery
+// before any document is read. A leading $match can be answered by an
+// index, and is the only one that may search the text index with $text.
+// A collection that does not exist aggregates like an empty one.
+func (d *Driver) Aggregate(collection string, pipeline []map[string]interface{}) ([]map[string]interface{}, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("Missing collection - unable to aggregate")
+	}
+
+	for i, spec := range pipeline {
+		if err := checkStage(spec); err != nil {
+			return nil, fmt.Errorf("%w (stage %d)", err, i)
+		}
+	}
+
+	// Only the documents a leading $match can select need to be read, and
+	// only that $match may search the text index, as in MongoDB.
+	query := map[string]interface{}{}
+	var text *textQuery
+	stages := make([]pipelineStage, len(pipeline))
+	for i, spec := range pipeline {
+		var (
+			stage pipelineStage
+			err   error
+		)
+		if m, ok := spec["$match"].(map[string]interface{}); ok && i == 0 {
+			var match matcher
+			if match, text, err = compileFilter(m); err == nil {
+				stage, query = matchStage(match), m
+			}
+		} else {
+			stage, err = d.compileStage(spec)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w (stage %d)", err, i)
+		}
+		stages[i] = stage
+	}
+	return d.runPipeline(collection, query, text, stages)
+}
+
+// runPipeline feeds the documents of collection that may match query and
+// text through stages.
+func (d *Driver) runPipeline(collection string, query map[string]interface{}, text *textQuery, stages []pipelineStage) ([]map[string]interface{}, error) {
+	resources, err := d.candidates(collection, query, text)
+	if os.IsNotExist(err) {
+		resources = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	docs := make([]map[string]interface{}, 0, len(resources))
+	err = d.eachRecord(collection, resources, nil, func(_ string, doc map[string]interface{}) bool {
+		docs = append(docs, doc)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stage := range stages {
+		docs = stage(docs)
+	}
+	return docs, nil
+}
+
+// checkStage makes sure spec names a single known stage, so that a bad
+// pipeline is rejected before a $lookup reads anything.
+func checkStage(spec map[string]interface{}) error {
+	if len(spec) != 1 {
+		return invalidQuery("a pipeline stage must have exactly one operator")
+	}
+	for op := range spec {
+		switch op {
+		case "$match", "$group", "$project", "$sort", "$skip", "$limit", "$unwind", "$count", "$lookup":
+			return nil
+		}
+		return invalidQuery("unknown pipeline stage %s", op)
+	}
+	return nil
+}
+
+func (d *Driver) compileStage(spec map[string]interface{}) (pipelineStage, error) {
+	for op, arg := range spec {
+		switch op {
+		case "$match":
+			return compileMatchStage(arg)
+		case "$group":
+			return compileGroupStage(arg)
+		case "$project":
+			return compileProjectStage(arg)
+		case "$sort":
+			return compileSortStage(arg)
+		case "$skip", "$limit":
+			return compilePageStage(op, arg)
+		case "$unwind":
+			return compileUnwindStage(arg)
+		case "$count":
+			return compileCountStage(arg)
+		case "$lookup":
+			opts, err := parseLookup(arg)
+			if err != nil {
+				return nil, err
+			}
+			return d.lookupStage(opts)
+		}
+	}
+	return nil, invalidQuery("a pipeline stage must have exactly one known operator")
+}
+
+func compileMatchStage(arg interface{}) (pipelineStage, error) {
+	query, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, invalidQuery("$match expects a query object")
+	}
+	if _, ok := query["$text"]; ok {
+		return nil, invalidQuery("$text is only allowed in the first $match stage")
+	}
+	match, err := compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return matchStage(match), nil
+}
+
+// matchStage keeps the documents that match.
+func matchStage(match matcher) pipelineStage {
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		kept := docs[:0]
+		for _, doc := range docs {
+			if match(doc) {
+				kept = append(kept, doc)
+			}
+		}
+		return kept
+	}
+}
+
+// expression computes a value from a document. ok is false when the value
+// is missing, for example because a referenced field does not exist.
+type expression func(doc map[string]interface{}) (v interface{}, ok bool)
+
+// compileExpression compiles an aggregation expression: a "$field.path"
+// reference, an object whose values are expressions, {"$literal": v}, or
+// any other literal value.
+func compileExpression(expr interface{}) (expression, error) {
+	switch x := expr.(type) {
+	case string:
+		if !strings.HasPrefix(x, "$") {
+			break
+		}
+		path := x[1:]
+		if path == "" {
+			return nil, invalidQuery("empty field reference %q", x)
+		}
+		return func(doc map[string]interface{}) (interface{}, bool) {
+			values := fieldValues(doc, path)
+			switch len(values) {
+			case 0:
+				return nil, false
+			case 1:
+				return values[0], true
+			}
+			return values, true
+		}, nil
+
+	case map[string]interface{}:
+		if lit, ok := x["$literal"]; ok && len(x) == 1 {
+			return func(map[string]interface{}) (interface{}, bool) {
+				return lit, true
+			}, nil
+		}
+		fields := make(map[string]expression, len(x))
+		for key, sub := range x {
+			if strings.HasPrefix(key, "$") {
+				return nil, invalidQuery("unknown expression operator %s", key)
+			}
+			e, err := compileExpression(sub)
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = e
+		}
+		return func(doc map[string]interface{}) (interface{}, bool) {
+			out := make(map[string]interface{}, len(fields))
+			for key, e := range fields {
+				if v, ok := e(doc); ok {
+					out[key] = v
+				}
+			}
+			return out, true
+		}, nil
+	}
+
+	return func(map[string]interface{}) (interface{}, bool) {
+		return expr, true
+	}, nil
+}
+
+// accumulator folds the documents of a group into a single value.
+type accumulator interface {
+	add(doc map[string]interface{})
+	result() interface{}
+}
+
+type sumAcc struct {
+	expr  expression
+	total float64
+}
+
+func (a *sumAcc) add(doc map[string]interface{}) {
+	if v, ok := a.expr(doc); ok {
+		if n, ok := toFloat(v); ok {
+			a.total += n
+		}
+	}
+}
+
+func (a *sumAcc) result() interface{} { return a.total }
+
+type avgAcc struct {
+	expr  expression
+	total float64
+	n     int
+}
+
+func (a *avgAcc) add(doc map[string]interface{}) {
+	if v, ok := a.expr(doc); ok {
+		if n, ok := toFloat(v); ok {
+			a.total += n
+			a.n++
+		}
+	}
+}
+
+func (a *avgAcc) result() interface{} {
+	if a.n == 0 {
+		return nil
+	}
+	return a.total / float64(a.n)
+}
+
+// extremeAcc implements $min and $max over values of any type, ordered
+// like query comparisons. Missing and null values are ignored.
+type extremeAcc struct {
+	expr  expression
+	max   bool
+	value interface{}
+}
+
+func (a *extremeAcc) add(doc map[string]interface{}) {
+	v, ok := a.expr(doc)
+	if !ok || v == nil {
+		return
+	}
+	if a.value == nil {
+		a.value = v
+		return
+	}
+	c := orderValues(v, a.value)
+	if (a.max && c > 0) || (!a.max && c < 0) {
+		a.value = v
+	}
+}
+
+func (a *extremeAcc) result() interface{} { return a.value }
+
+type pushAcc struct {
+	expr   expression
+	values []interface{}
+}
+
+func (a *pushAcc) add(doc map[string]interface{}) {
+	if v, ok := a.expr(doc); ok {
+		a.values = append(a.values, v)
+	}
+}
+
+func (a *pushAcc) result() interface{} {
+	if a.values == nil {
+		return []interface{}{}
+	}
+	return a.values
+}
+
+type countAcc struct {
+	n int
+}
+
+func (a *countAcc) add(map[string]interface{}) { a.n++ }
+
+func (a *countAcc) result() interface{} { return float64(a.n) }
+
+// compileAccumulator returns a constructor for the accumulator described
+// by spec, such as {"$sum": "$amount"}.
+func compileAccumulator(field string, spec interface{}) (func() accumulator, error) {
+	m, ok := spec.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, invalidQuery("$group field %q must be an accumulator object", field)
+	}
+
+	for op, arg := range m {
+		if op == "$count" {
+			return func() accumulator { return &countAcc{} }, nil
+		}
+
+		expr, err := compileExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "$sum":
+			return func() accumulator { return &sumAcc{expr: expr} }, nil
+		case "$avg":
+			return func() accumulator { return &avgAcc{expr: expr} }, nil
+		case "$min":
+			return func() accumulator { return &extremeAcc{expr: expr} }, nil
+		case "$max":
+			return func() accumulator { return &extremeAcc{expr: expr, max: true} }, nil
+		case "$push":
+			return func() accumulator { return &pushAcc{expr: expr} }, nil
+		}
+		return nil, invalidQuery("unknown accumulator %s", op)
+	}
+	return nil, nil
+}
+
+// compileGroupStage compiles $group. Groups are emitted in the order in
+// which their first document arrived.
+func compileGroupStage(arg interface{}) (pipelineStage, error) {
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return nil, invalidQuery("$group expects an object")
+	}
+	idSpec, ok := spec["_id"]
+	if !ok {
+		return nil, invalidQuery("$group requires an _id")
+	}
+	id, err := compileExpression(idSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]func() accumulator, len(spec)-1)
+	for field, acc := range spec {
+		if field == "_id" {
+			continue
+		}
+		if fields[field], err = compileAccumulator(field, acc); err != nil {
+			return nil, err
+		}
+	}
+
+	type group struct {
+		id   interface{}
+		accs map[string]accumulator
+	}
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		var order []*group
+		groups := make(map[string]*group)
+		for _, doc := range docs {
+			key, _ := id(doc)
+			k := valueKey(key)
+			g, ok := groups[k]
+			if !ok {
+				g = &group{id: key, accs: make(map[string]accumulator, len(fields))}
+				for field, newAcc := range fields {
+					g.accs[field] = newAcc()
+				}
+				groups[k] = g
+				order = append(order, g)
+			}
+			for _, acc := range g.accs {
+				acc.add(doc)
+			}
+		}
+
+		out := make([]map[string]interface{}, len(order))
+		for i, g := range order {
+			doc := map[string]interface{}{"_id": g.id}
+			for field, acc := range g.accs {
+				doc[field] = acc.result()
+			}
+			out[i] = doc
+		}
+		return out
+	}, nil
+}
+
+// compileProjectStage compiles $project. A field set to 1 or true is kept,
+// one set to 0 or false is removed, and any other value is an expression
+// computing the field. Kept and removed fields cannot be mixed, except
+// for "_id", which is kept unless it is removed.
+func compileProjectStage(arg interface{}) (pipelineStage, error) {
+	spec, ok := arg.(map[string]interface{})
+	if !ok || len(spec) == 0 {
+		return nil, invalidQuery("$project expects a non-empty object")
+	}
+
+	projection := make(map[string]bool)
+	computed := make(map[string]expression)
+	for field, v := range spec {
+		if b, ok := projectionFlag(v); ok {
+			projection[field] = b
+			continue
+		}
+		e, err := compileExpression(v)
+		if err != nil {
+			return nil, err
+		}
+		computed[field] = e
+	}
+	opts := FindOptions{Projection: projection}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	for field, keep := range projection {
+		if !keep && field != idField && len(computed) > 0 {
+			return nil, invalidQuery("$project cannot mix excluded and computed fields")
+		}
+	}
+
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		for i, doc := range docs {
+			out := doc
+			switch {
+			case len(computed) > 0 && !inclusive(projection):
+				// Only the computed fields, and "_id" unless excluded.
+				out = make(map[string]interface{})
+				if keep, ok := projection[idField]; !ok || keep {
+					includePath(out, doc, []string{idField})
+				}
+			case len(projection) > 0:
+				out = project(doc, projection)
+			}
+			for field, e := range computed {
+				if v, ok := e(doc); ok {
+					setField(out, field, v)
+				}
+			}
+			docs[i] = out
+		}
+		return docs
+	}, nil
+}
+
+// projectionFlag recognizes the include (1, true) and exclude (0, false)
+// values of $project.
+func projectionFlag(v interface{}) (bool, bool) {
+	if b, ok := v.(bool); ok {
+		return b, true
+	}
+	if n, ok := toFloat(v); ok && (n == 0 || n == 1) {
+		return n == 1, true
+	}
+	return false, false
+}
+
+// compileSortStage compiles $sort. Because Go does not keep the order of
+// JSON object keys, sorting by several fields takes an array of
+// single-field objects: {"$sort": [{"category": 1}, {"total": -1}]}.
+func compileSortStage(arg interface{}) (pipelineStage, error) {
+	var specs []interface{}
+	switch x := arg.(type) {
+	case map[string]interface{}:
+		if len(x) != 1 {
+			return nil, invalidQuery("$sort on several fields expects an array of single-field objects")
+		}
+		specs = []interface{}{x}
+	case []interface{}:
+		specs = x
+	}
+	if len(specs) == 0 {
+		return nil, invalidQuery("$sort expects an object or an array of objects")
+	}
+
+	var s sorter
+	for _, spec := range specs {
+		m, ok := spec.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, invalidQuery("$sort on several fields expects an array of single-field objects")
+		}
+		for path, dir := range m {
+			n, ok := toFloat(dir)
+			if !ok || (n != 1 && n != -1) {
+				return nil, invalidQuery("$sort direction of %q must be 1 or -1", path)
+			}
+			s.fields = append(s.fields, SortField{Path: path, Desc: n < 0})
+		}
+	}
+
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		items := make([]sortItem, len(docs))
+		for i, doc := range docs {
+			items[i] = s.item(Record{}, doc)
+			items[i].rec.Document = doc
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			return s.less(items[i], items[j])
+		})
+		for i, it := range items {
+			docs[i] = it.rec.Document
+		}
+		return docs
+	}, nil
+}
+
+func compilePageStage(op string, arg interface{}) (pipelineStage, error) {
+	n, ok := toFloat(arg)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, invalidQuery("%s expects a non-negative integer", op)
+	}
+	if op == "$limit" && n == 0 {
+		return nil, invalidQuery("$limit must be positive")
+	}
+	if op == "$skip" {
+		return func(docs []map[string]interface{}) []map[string]interface{} {
+			if int(n) >= len(docs) {
+				return nil
+			}
+			return docs[int(n):]
+		}, nil
+	}
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		if int(n) < len(docs) {
+			return docs[:int(n)]
+		}
+		return docs
+	}, nil
+}
+
+// compileUnwindStage compiles $unwind, which outputs a document for every
+// element of an array field. It accepts "$path" or
+// {"path": "$path", "preserveNullAndEmptyArrays": true}; the latter keeps
+// documents whose field is missing, null or an empty array.
+func compileUnwindStage(arg interface{}) (pipelineStage, error) {
+	path, preserve := "", false
+	switch x := arg.(type) {
+	case string:
+		path = x
+	case map[string]interface{}:
+		path, _ = x["path"].(string)
+		if p, ok := x["preserveNullAndEmptyArrays"]; ok {
+			if preserve, ok = p.(bool); !ok {
+				return nil, invalidQuery("$unwind preserveNullAndEmptyArrays must be true or false")
+			}
+		}
+	}
+	if !strings.HasPrefix(path, "$") || len(path) == 1 {
+		return nil, invalidQuery("$unwind expects a field path such as \"$tags\"")
+	}
+	path = path[1:]
+
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		var out []map[string]interface{}
+		for _, doc := range docs {
+			v, _ := getField(doc, path)
+			arr, isArray := v.([]interface{})
+			switch {
+			case isArray && len(arr) > 0:
+				for _, elem := range arr {
+					copied := copyValue(doc).(map[string]interface{})
+					setField(copied, path, elem)
+					out = append(out, copied)
+				}
+			case v != nil && !isArray:
+				// A scalar unwinds to the document itself.
+				out = append(out, doc)
+			case preserve:
+				out = append(out, doc)
+			}
+		}
+		return out
+	}, nil
+}
+
+// copyValue deep-copies a decoded JSON value.
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = copyValue(e)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, e := range x {
+			arr[i] = copyValue(e)
+		}
+		return arr
+	}
+	return v
+}
+
+// compileCountStage compiles $count, which replaces the documents with a
+// single one holding their number in the named field.
+func compileCountStage(arg interface{}) (pipelineStage, error) {
+	field, ok := arg.(string)
+	if !ok || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return nil, invalidQuery("$count expects a field name")
+	}
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		if len(docs) == 0 {
+			return nil
+		}
+		return []map[string]interface{}{{field: float64(len(docs))}}
+	}, nil
+}
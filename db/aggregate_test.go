@@ -0,0 +1,163 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// aggregateTestOrders are the documents of collection "orders" in the
+// aggregation tests, written in resource name order.
+var aggregateTestOrders = []string{
+	`{"category": "books", "amount": 10, "status": "paid", "tags": ["a", "b"]}`,
+	`{"category": "books", "amount": 30, "status": "paid", "tags": []}`,
+	`{"category": "games", "amount": 20, "status": "paid", "tags": ["b"]}`,
+	`{"category": "games", "amount": 5, "status": "open"}`,
+	`{"category": "music", "amount": "7", "status": "paid", "tags": "c"}`,
+}
+
+// aggregate runs pipeline, given as JSON, over d's orders and returns the
+// result as JSON.
+func aggregate(t *testing.T, d *Driver, pipeline string) (string, error) {
+	t.Helper()
+	var stages []map[string]interface{}
+	if err := json.Unmarshal([]byte(pipeline), &stages); err != nil {
+		t.Fatalf("%s: %v", pipeline, err)
+	}
+	docs, err := d.Aggregate("orders", stages)
+	if err != nil {
+		return "", err
+	}
+	if docs == nil {
+		docs = []map[string]interface{}{}
+	}
+	b, err := json.Marshal(docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+// sameJSON reports whether two JSON documents hold the same value.
+func sameJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		t.Fatalf("%s: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		t.Fatalf("%s: %v", b, err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestAggregate(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for i, doc := range aggregateTestOrders {
+		if err := d.Write("orders", string(rune('a'+i)), json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pipeline string
+		want     string
+	}{
+		{"project", `[{"$project": {"amount": 1}}]`,
+			`[{"_id": "a", "amount": 10}, {"_id": "b", "amount": 30}, {"_id": "c", "amount": 20}, {"_id": "d", "amount": 5}, {"_id": "e", "amount": "7"}]`},
+		{"match", `[{"$match": {"status": "open"}}, {"$project": {"category": 1}}]`,
+			`[{"_id": "d", "category": "games"}]`},
+		{"group", `[{"$match": {"status": "paid"}},
+			{"$group": {"_id": "$category", "total": {"$sum": "$amount"}, "avg": {"$avg": "$amount"},
+				"min": {"$min": "$amount"}, "max": {"$max": "$amount"}, "n": {"$count": {}}}}]`,
+			`[{"_id": "books", "total": 40, "avg": 20, "min": 10, "max": 30, "n": 2},
+			  {"_id": "games", "total": 20, "avg": 20, "min": 20, "max": 20, "n": 1},
+			  {"_id": "music", "total": 0, "avg": null, "min": "7", "max": "7", "n": 1}]`},
+		{"group all", `[{"$group": {"_id": null, "amounts": {"$push": "$amount"}}}]`,
+			`[{"_id": null, "amounts": [10, 30, 20, 5, "7"]}]`},
+		{"group by computed key", `[{"$group": {"_id": {"c": "$category", "s": "$status"}, "n": {"$count": {}}}}, {"$match": {"_id.c": "games"}}]`,
+			`[{"_id": {"c": "games", "s": "paid"}, "n": 1}, {"_id": {"c": "games", "s": "open"}, "n": 1}]`},
+		{"sort and page", `[{"$sort": [{"category": -1}, {"amount": 1}]}, {"$skip": 1}, {"$limit": 2}, {"$project": {"amount": 1}}]`,
+			`[{"_id": "d", "amount": 5}, {"_id": "c", "amount": 20}]`},
+		{"project computed fields", `[{"$match": {"amount": 10}}, {"$project": {"kind": "$category", "fixed": {"$literal": "$x"}, "sub": {"s": "$status"}}}]`,
+			`[{"_id": "a", "kind": "books", "fixed": "$x", "sub": {"s": "paid"}}]`},
+		{"project excluded fields", `[{"$match": {"amount": 5}}, {"$project": {"tags": 0, "status": false}}]`,
+			`[{"_id": "d", "category": "games", "amount": 5}]`},
+		{"unwind", `[{"$unwind": "$tags"}, {"$project": {"tags": 1}}]`,
+			`[{"_id": "a", "tags": "a"}, {"_id": "a", "tags": "b"}, {"_id": "c", "tags": "b"}, {"_id": "e", "tags": "c"}]`},
+		{"unwind preserving empty arrays", `[{"$unwind": {"path": "$tags", "preserveNullAndEmptyArrays": true}}, {"$count": "n"}]`,
+			`[{"n": 6}]`},
+		{"count", `[{"$match": {"status": "paid"}}, {"$count": "paid"}]`,
+			`[{"paid": 4}]`},
+		{"count nothing", `[{"$match": {"status": "void"}}, {"$count": "n"}]`,
+			`[]`},
+	}
+	for _, tt := range tests {
+		got, err := aggregate(t, d, tt.pipeline)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameJSON(t, got, tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// A missing collection aggregates like an empty one.
+	docs, err := d.Aggregate("missing", []map[string]interface{}{{"$count": "n"}})
+	if err != nil || len(docs) != 0 {
+		t.Errorf("missing collection: %v, %v", docs, err)
+	}
+}
+
+func TestAggregateRejects(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, pipeline := range []string{
+		`[{"$out": "x"}]`,
+		`[{"$match": {}, "$limit": 1}]`,
+		`[{"$match": 1}]`,
+		`[{"$match": {"a": {"$bad": 1}}}]`,
+		`[{"$group": {"total": {"$sum": "$amount"}}}]`,
+		`[{"$group": {"_id": null, "total": {"$median": "$amount"}}}]`,
+		`[{"$group": {"_id": null, "total": 1}}]`,
+		`[{"$group": {"_id": "$"}}]`,
+		`[{"$project": {}}]`,
+		`[{"$project": {"a": 1, "b": 0}}]`,
+		`[{"$project": {"a": 0, "b": "$c"}}]`,
+		`[{"$project": {"a": {"$toUpper": "$b"}}}]`,
+		`[{"$sort": {"a": 1, "b": -1}}]`,
+		`[{"$sort": {"a": 2}}]`,
+		`[{"$skip": -1}]`,
+		`[{"$limit": 0}]`,
+		`[{"$limit": 1.5}]`,
+		`[{"$unwind": "tags"}]`,
+		`[{"$unwind": {"path": "$tags", "preserveNullAndEmptyArrays": "yes"}}]`,
+		`[{"$count": "a.b"}]`,
+	} {
+		if _, err := aggregate(t, d, pipeline); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: err = %v, want ErrInvalidQuery", pipeline, err)
+		}
+	}
+}
+
+func TestRegexSearchRejectsBadPatterns(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("c", "1", map[string]interface{}{"name": "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := map[string]string{"name": "x", "email": "("}
+	if _, err := d.RegexSearch("c", query); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("RegexSearch: err = %v, want ErrInvalidQuery", err)
+	}
+	calls := 0
+	err := d.RegexSearchEach("c", query, func(map[string]interface{}) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidQuery) || calls != 0 {
+		t.Errorf("RegexSearchEach: err = %v after %d records, want ErrInvalidQuery before any", err, calls)
+	}
+}
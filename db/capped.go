@@ -0,0 +1,362 @@
+package db
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// cappedFile holds the insertion order of a capped collection in its
+// settings directory.
+const cappedFile = "capped.json"
+
+// CappedSpec bounds a capped collection. Writing a document that takes
+// the collection over a limit evicts its oldest documents, like a ring
+// buffer. A zero limit is not enforced.
+type CappedSpec struct {
+	MaxDocs  int   `json:"maxDocs,omitempty"`
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// cappedEntry is a document of a capped collection and its stored size.
+type cappedEntry struct {
+	resource string
+	size     int64
+}
+
+// cappedOrder keeps the documents of a capped collection in insertion
+// order. Replacing a document keeps its place.
+type cappedOrder struct {
+	entries *list.List // of *cappedEntry, oldest first
+	elems   map[string]*list.Element
+	bytes   int64
+	dirty   bool // changed since the last snapshot
+}
+
+func newCappedOrder() *cappedOrder {
+	return &cappedOrder{entries: list.New(), elems: make(map[string]*list.Element)}
+}
+
+// put appends resource, or updates its size if it is already present.
+func (c *cappedOrder) put(resource string, size int64) {
+	if el, ok := c.elems[resource]; ok {
+		e := el.Value.(*cappedEntry)
+		c.bytes += size - e.size
+		e.size = size
+	} else {
+		c.elems[resource] = c.entries.PushBack(&cappedEntry{resource: resource, size: size})
+		c.bytes += size
+	}
+	c.dirty = true
+}
+
+func (c *cappedOrder) remove(resource string) {
+	el, ok := c.elems[resource]
+	if !ok {
+		return
+	}
+	c.bytes -= el.Value.(*cappedEntry).size
+	c.entries.Remove(el)
+	delete(c.elems, resource)
+	c.dirty = true
+}
+
+func (c *cappedOrder) clear() {
+	c.entries.Init()
+	c.elems = make(map[string]*list.Element)
+	c.bytes = 0
+	c.dirty = true
+}
+
+// resources lists the documents oldest first.
+func (c *cappedOrder) resources() []string {
+	names := make([]string, 0, c.entries.Len())
+	for el := c.entries.Front(); el != nil; el = el.Next() {
+		names = append(names, el.Value.(*cappedEntry).resource)
+	}
+	return names
+}
+
+// evictions returns the oldest documents to delete so that the collection
+// fits spec once the given changes are applied. changes maps the resources
+// a commit writes to their new size and the ones it deletes to -1;
+// cleared says that the commit first deletes the whole collection.
+// Documents the commit writes are never evicted, so ok is false when they
+// alone exceed the limits.
+func (c *cappedOrder) evictions(spec *CappedSpec, changes map[string]int64, cleared bool) (evicted []string, ok bool) {
+	count, bytes := c.entries.Len(), c.bytes
+	if cleared {
+		count, bytes = 0, 0
+	}
+	for resource, size := range changes {
+		if el, ok := c.elems[resource]; ok && !cleared {
+			count--
+			bytes -= el.Value.(*cappedEntry).size
+		}
+		if size >= 0 {
+			count++
+			bytes += size
+		}
+	}
+
+	over := func() bool {
+		return (spec.MaxDocs > 0 && count > spec.MaxDocs) || (spec.MaxBytes > 0 && bytes > spec.MaxBytes)
+	}
+	for el := c.entries.Front(); el != nil && over() && !cleared; el = el.Next() {
+		e := el.Value.(*cappedEntry)
+		if _, ok := changes[e.resource]; ok {
+			continue
+		}
+		evicted = append(evicted, e.resource)
+		count--
+		bytes -= e.size
+	}
+	return evicted, !over()
+}
+
+// cappedEvictions returns the delete operations that keep the capped
+// collections written by ops within their limits. The caller must hold
+// the collection mutexes.
+func (d *Driver) cappedEvictions(ops []walOp) ([]walOp, error) {
+	changes := make(map[string]map[string]int64)
+	cleared := make(map[string]bool)
+	var written []string
+	for _, op := range ops {
+		if changes[op.Collection] == nil {
+			changes[op.Collection] = make(map[string]int64)
+		}
+		switch op.Kind {
+		case opWrite:
+			written = append(written, op.Collection)
+			changes[op.Collection][op.Resource] = int64(len(op.Data))
+		case opDelete:
+			changes[op.Collection][op.Resource] = -1
+		case opDeleteAll:
+			changes[op.Collection] = make(map[string]int64)
+			cleared[op.Collection] = true
+		}
+	}
+
+	var evictions []walOp
+	for _, collection := range sortedUnique(written) {
+		cs := d.state(collection)
+		if cs == nil {
+			continue
+		}
+		cs.mu.RLock()
+		var evicted []string
+		ok := true
+		if cs.meta.Capped != nil && cs.capped != nil {
+			evicted, ok = cs.capped.evictions(cs.meta.Capped, changes[collection], cleared[collection])
+		}
+		cs.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("Capped collection %s is too small for the documents written", collection)
+		}
+		for _, resource := range evicted {
+			evictions = append(evictions, walOp{Kind: opDelete, Collection: collection, Resource: resource})
+		}
+	}
+	return evictions, nil
+}
+
+// SetCapped makes collection a capped collection bounded by spec, or an
+// ordinary one again when spec is nil. Documents already stored count as
+// inserted in the order of their modification times; the oldest are
+// evicted at once if the collection exceeds the new limits.
+func (d *Driver) SetCapped(collection string, spec *CappedSpec) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to cap!")
+	}
+	if spec != nil {
+		if spec.MaxDocs < 0 || spec.MaxBytes < 0 || (spec.MaxDocs == 0 && spec.MaxBytes == 0) {
+			return fmt.Errorf("Invalid capped collection %s: set a positive maxDocs and/or maxBytes", collection)
+		}
+		spec = &CappedSpec{MaxDocs: spec.MaxDocs, MaxBytes: spec.MaxBytes}
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	order, err := d.saveCapped(collection, spec)
+	if err != nil || spec == nil {
+		return err
+	}
+
+	cs := d.state(collection)
+	cs.mu.RLock()
+	evicted, _ := order.evictions(spec, nil, false)
+	cs.mu.RUnlock()
+	if len(evicted) == 0 {
+		return nil
+	}
+	ops := make([]walOp, len(evicted))
+	for i, resource := range evicted {
+		ops[i] = walOp{Kind: opDelete, Collection: collection, Resource: resource}
+	}
+	return d.commit(ops)
+}
+
+// saveCapped persists the capped settings of collection and returns its
+// insertion order, which is built when the collection becomes capped.
+// The caller must hold the collection mutex.
+func (d *Driver) saveCapped(collection string, spec *CappedSpec) (*cappedOrder, error) {
+	d.ckpt.RLock()
+	defer d.ckpt.RUnlock()
+
+	cs := d.getOrCreateState(collection)
+	cs.mu.RLock()
+	order := cs.capped
+	cs.mu.RUnlock()
+	if spec != nil && order == nil {
+		var err error
+		if order, err = d.buildCappedOrder(collection); err != nil {
+			return nil, err
+		}
+		if err := d.saveCappedOrder(collection, order); err != nil {
+			return nil, err
+		}
+	}
+
+	meta := cs.meta
+	meta.Capped = spec
+	if err := d.saveMeta(collection, meta); err != nil {
+		return nil, err
+	}
+
+	cs.mu.Lock()
+	cs.meta = meta
+	if spec == nil {
+		cs.capped = nil
+	} else {
+		cs.capped = order
+	}
+	cs.mu.Unlock()
+
+	if spec == nil {
+		err := os.Remove(d.cappedPath(collection))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// Capped returns the limits of collection, or nil if it is not capped.
+func (d *Driver) Capped(collection string) *CappedSpec {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.meta.Capped == nil {
+		return nil
+	}
+	spec := *cs.meta.Capped
+	return &spec
+}
+
+// insertionOrder returns the resources of a capped collection oldest
+// first. ok is false when the collection is not capped.
+func (d *Driver) insertionOrder(collection string) (resources []string, ok bool) {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil, false
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.capped == nil {
+		return nil, false
+	}
+	return cs.capped.resources(), true
+}
+
+// cappedSnapshot is the on-disk form of the insertion order of a capped
+// collection.
+type cappedSnapshot struct {
+	Entries []struct {
+		Resource string `json:"r"`
+		Size     int64  `json:"s"`
+	} `json:"entries"`
+}
+
+func (d *Driver) cappedPath(collection string) string {
+	return filepath.Join(d.dir, metaDir, collection, cappedFile)
+}
+
+// saveCappedOrder writes a snapshot of the insertion order of collection.
+// Like index snapshots, changes made after it are recovered by replaying
+// the write-ahead log.
+func (d *Driver) saveCappedOrder(collection string, c *cappedOrder) error {
+	var snap cappedSnapshot
+	for el := c.entries.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cappedEntry)
+		snap.Entries = append(snap.Entries, struct {
+			Resource string `json:"r"`
+			Size     int64  `json:"s"`
+		}{e.resource, e.size})
+	}
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := d.writeFileAtomic(d.cappedPath(collection), b); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// loadCappedOrder restores the insertion order of collection from its
+// snapshot, or rebuilds it if the snapshot is missing or unreadable.
+func (d *Driver) loadCappedOrder(collection string) (*cappedOrder, error) {
+	var snap cappedSnapshot
+	b, err := ioutil.ReadFile(d.cappedPath(collection))
+	if err == nil && json.Unmarshal(b, &snap) == nil {
+		c := newCappedOrder()
+		for _, e := range snap.Entries {
+			c.put(e.Resource, e.Size)
+		}
+		c.dirty = false
+		return c, nil
+	}
+
+	d.log.Info("Rebuilding insertion order of capped collection %s", collection)
+	return d.buildCappedOrder(collection)
+}
+
+// buildCappedOrder orders the documents of collection by modification
+// time, the best guess at their insertion order.
+func (d *Driver) buildCappedOrder(collection string) (*cappedOrder, error) {
+	c := newCappedOrder()
+	files, err := ioutil.ReadDir(filepath.Join(d.dir, collection))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			records = append(records, file)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ModTime().Before(records[j].ModTime())
+	})
+	for _, file := range records {
+		c.put(file.Name()[:len(file.Name())-len(".json")], file.Size())
+	}
+	return c, nil
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCappedEvictions(t *testing.T) {
+	// The order holds a, b, c and d, oldest first, of 10 bytes each.
+	tests := []struct {
+		name    string
+		spec    CappedSpec
+		changes map[string]int64
+		cleared bool
+		want    []string
+		ok      bool
+	}{
+		{"within limits", CappedSpec{MaxDocs: 5}, map[string]int64{"e": 10}, false, nil, true},
+		{"oldest first", CappedSpec{MaxDocs: 4}, map[string]int64{"e": 10, "f": 10}, false, []string{"a", "b"}, true},
+		{"replacing keeps the count", CappedSpec{MaxDocs: 4}, map[string]int64{"a": 10}, false, nil, true},
+		{"written documents are kept", CappedSpec{MaxDocs: 3}, map[string]int64{"a": 10}, false, []string{"b"}, true},
+		{"deletes make room", CappedSpec{MaxDocs: 4}, map[string]int64{"c": -1, "e": 10}, false, nil, true},
+		{"bytes", CappedSpec{MaxBytes: 45}, map[string]int64{"e": 15}, false, []string{"a"}, true},
+		{"growing a document", CappedSpec{MaxBytes: 40}, map[string]int64{"d": 25}, false, []string{"a", "b"}, true},
+		{"both limits", CappedSpec{MaxDocs: 4, MaxBytes: 85}, map[string]int64{"e": 60}, false, []string{"a", "b"}, true},
+		{"cleared", CappedSpec{MaxDocs: 2}, map[string]int64{"e": 10}, true, nil, true},
+		{"too small", CappedSpec{MaxBytes: 20}, map[string]int64{"e": 30}, false, []string{"a", "b", "c", "d"}, false},
+		{"too small after a clear", CappedSpec{MaxDocs: 1}, map[string]int64{"e": 10, "f": 10}, true, nil, false},
+	}
+	for _, tt := range tests {
+		c := newCappedOrder()
+		for _, resource := range []string{"a", "b", "c", "d"} {
+			c.put(resource, 10)
+		}
+		evicted, ok := c.evictions(&tt.spec, tt.changes, tt.cleared)
+		if !reflect.DeepEqual(evicted, tt.want) || ok != tt.ok {
+			t.Errorf("%s: evictions = %v, %v, want %v, %v", tt.name, evicted, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// readAllNames returns the resource field of every document ReadAllWith
+// returns for collection with opts, in order.
+func readAllNames(t *testing.T, d *Driver, collection string, opts *FindOptions) []string {
+	t.Helper()
+	records, _, err := d.ReadAllWith(collection, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range records {
+		var doc struct{ R string }
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, doc.R)
+	}
+	return names
+}
+
+func TestCappedCollection(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(resource string) {
+		t.Helper()
+		if err := d.Write("log", resource, map[string]interface{}{"r": resource}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, spec := range []*CappedSpec{{}, {MaxDocs: -1}, {MaxBytes: -1}} {
+		if err := d.SetCapped("log", spec); err == nil {
+			t.Errorf("SetCapped(%+v) succeeded", spec)
+		}
+	}
+	if err := d.SetCapped("log", &CappedSpec{MaxDocs: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range []string{"z", "y", "x", "w"} {
+		write(resource)
+	}
+	// Replacing y keeps its place, so it is the oldest when v comes.
+	write("y")
+	write("v")
+
+	want := []string{"x", "w", "v"}
+	if got := readAllNames(t, d, "log", nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAllWith: %v, want %v", got, want)
+	}
+	if got := readAllNames(t, d, "log", &FindOptions{Sort: []SortField{{Path: "r"}}}); !reflect.DeepEqual(got, []string{"v", "w", "x"}) {
+		t.Errorf("sorted ReadAllWith: %v", got)
+	}
+	var iterated []string
+	if err := d.Iterate("log", func(resource string, _ json.RawMessage) error {
+		iterated = append(iterated, resource)
+		return nil
+	}); err != nil || !reflect.DeepEqual(iterated, want) {
+		t.Errorf("Iterate: %v, %v, want %v", iterated, err, want)
+	}
+
+	// Cursor pages follow the insertion order too.
+	opts := &FindOptions{Limit: 2}
+	records, next, err := d.ReadAllWith("log", opts)
+	if err != nil || len(records) != 2 || next == "" {
+		t.Fatalf("first page: %d records, cursor %q, %v", len(records), next, err)
+	}
+	if got := readAllNames(t, d, "log", &FindOptions{Limit: 2, Cursor: next}); !reflect.DeepEqual(got, []string{"v"}) {
+		t.Errorf("second page: %v, want [v]", got)
+	}
+	if err := d.Delete("log", "w"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.ReadAllWith("log", &FindOptions{Limit: 2, Cursor: next}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("cursor at a deleted document: %v, want ErrInvalidQuery", err)
+	}
+
+	// A transaction that writes more than the collection holds fails as a
+	// whole.
+	err = d.Txn(func(tx *Tx) error {
+		for i := 0; i < 4; i++ {
+			tx.Write("log", fmt.Sprint("t", i), map[string]interface{}{"r": "t"})
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatal("a transaction overflowed the capped collection")
+	}
+
+	// The insertion order survives a restart, and shrinking the limits
+	// evicts at once.
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if spec := d.Capped("log"); spec == nil || *spec != (CappedSpec{MaxDocs: 3}) {
+		t.Fatalf("Capped after a restart: %+v", spec)
+	}
+	write("u")
+	if got := readAllNames(t, d, "log", nil); !reflect.DeepEqual(got, []string{"x", "v", "u"}) {
+		t.Errorf("after a restart: %v", got)
+	}
+	if err := d.SetCapped("log", &CappedSpec{MaxDocs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAllNames(t, d, "log", nil); !reflect.DeepEqual(got, []string{"u"}) {
+		t.Errorf("after shrinking: %v, want [u]", got)
+	}
+
+	if err := d.SetCapped("log", nil); err != nil {
+		t.Fatal(err)
+	}
+	if d.Capped("log") != nil {
+		t.Error("still capped")
+	}
+	write("t")
+	write("s")
+	if got := readAllNames(t, d, "log", nil); !reflect.DeepEqual(got, []string{"s", "t", "u"}) {
+		t.Errorf("uncapped: %v, want name order", got)
+	}
+}
+
+func TestCappedEvictionReleasesUniqueKeys(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.SetCapped("c", &CappedSpec{MaxDocs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateIndex("c", "k", &IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "a", map[string]interface{}{"k": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// Writing b evicts a, so its key is free.
+	if err := d.Write("c", "b", map[string]interface{}{"k": 1}); err != nil {
+		t.Fatalf("writing the key of the evicted document: %v", err)
+	}
+	if _, err := d.readStored("c", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a was not evicted: %v", err)
+	}
+
+	// A document that stays still holds its key.
+	if err := d.SetCapped("c", &CappedSpec{MaxDocs: 2}); err != nil {
+		t.Fatal(err)
+	}
+	var dup *DuplicateKeyError
+	if err := d.Write("c", "e", map[string]interface{}{"k": 1}); !errors.As(err, &dup) || dup.Resource != "b" {
+		t.Errorf("duplicate of a kept document: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// metaDir holds the settings of every collection, such as its index
+	// definitions, inside the database directory.
+	metaDir     = ".meta"
+	catalogFile = "catalog.json"
+)
+
+// collectionMeta is the persisted configuration of a collection.
+type collectionMeta struct {
+	Indexes []IndexSpec `json:"indexes,omitempty"`
+	TTL     *TTLSpec    `json:"ttl,omitempty"`
+	Capped  *CappedSpec `json:"capped,omitempty"`
+	// Validator is the JSON Schema documents must match.
+	Validator *Validator `json:"validator,omitempty"`
+	// Expiring is set once a document of the collection has been written
+	// with an expiry time.
+	Expiring bool `json:"expiring,omitempty"`
+}
+
+// collState is the in-memory state the driver keeps for a collection. The
+// collection mutex serializes changes to it; mu additionally protects it
+// from readers that do not take the collection mutex.
+type collState struct {
+	mu      sync.RWMutex
+	meta    collectionMeta
+	indexes map[string]*index
+	text    *textIndex   // the text index, if the collection has one
+	capped  *cappedOrder // the insertion order, if the collection is capped
+	schema  *schema      // the compiled meta.Validator schema
+}
+
+// state returns the in-memory state of collection, or nil if the
+// collection has no settings.
+func (d *Driver) state(collection string) *collState {
+	d.catalogMu.Lock()
+	defer d.catalogMu.Unlock()
+	return d.colls[collection]
+}
+
+// getOrCreateState returns the in-memory state of collection, creating an
+// empty one if needed.
+func (d *Driver) getOrCreateState(collection string) *collState {
+	d.catalogMu.Lock()
+	defer d.catalogMu.Unlock()
+
+	cs, ok := d.colls[collection]
+	if !ok {
+		cs = &collState{indexes: make(map[string]*index)}
+		d.colls[collection] = cs
+	}
+	return cs
+}
+
+// loadCatalog reads the settings of every collection and restores their
+// indexes.
+func (d *Driver) loadCatalog() error {
+	dirs, err := ioutil.ReadDir(filepath.Join(d.dir, metaDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		collection := dir.Name()
+		b, err := ioutil.ReadFile(filepath.Join(d.dir, metaDir, collection, catalogFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		cs := d.getOrCreateState(collection)
+		if err := json.Unmarshal(b, &cs.meta); err != nil {
+			return err
+		}
+		if v := cs.meta.Validator; v != nil {
+			if cs.schema, err = compileSchema(v.Schema); err != nil {
+				return err
+			}
+		}
+		if cs.meta.Capped != nil {
+			if cs.capped, err = d.loadCappedOrder(collection); err != nil {
+				return err
+			}
+		}
+		for _, spec := range cs.meta.Indexes {
+			if spec.Text {
+				if cs.text, err = d.loadTextIndex(collection, spec); err != nil {
+					return err
+				}
+				continue
+			}
+			idx, err := d.loadIndex(collection, spec)
+			if err != nil {
+				return err
+			}
+			cs.indexes[spec.Name] = idx
+		}
+	}
+	return nil
+}
+
+// saveMeta persists the settings of collection. The caller must hold the
+// collection mutex.
+func (d *Driver) saveMeta(collection string, meta collectionMeta) error {
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+	return d.writeFileAtomic(filepath.Join(d.dir, metaDir, collection, catalogFile), b)
+}
+
+// writeFileAtomic replaces the file at path with b, creating its directory
+// if needed.
+func (d *Driver) writeFileAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	d.wal.touch(path, filepath.Dir(path))
+	return nil
+}
+
+// collections lists the collections stored in the database directory.
+// Directories used internally by the driver start with a dot and are
+// skipped.
+func (d *Driver) collections() ([]string, error) {
+	dirs, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, dir := range dirs {
+		if dir.IsDir() && !strings.HasPrefix(dir.Name(), ".") {
+			names = append(names, dir.Name())
+		}
+	}
+	return names, nil
+}
+
+// resources lists the resource names stored in collection, sorted. The
+// names are sorted themselves, as file names sort differently: "a-1.json"
+// comes before "a.json".
+func (d *Driver) resources(collection string) ([]string, error) {
+	files, err := ioutil.ReadDir(filepath.Join(d.dir, collection))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// toFloat converts any Go or JSON numeric value to a float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// dateValue recognizes a date: a time.Time, or its JSON form
+// {"$date": "2024-01-02T15:04:05Z"} holding an RFC 3339 timestamp or the
+// milliseconds since the Unix epoch.
+func dateValue(v interface{}) (time.Time, bool) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, true
+	case map[string]interface{}:
+		if len(x) != 1 {
+			return time.Time{}, false
+		}
+		raw, ok := x["$date"]
+		if !ok {
+			return time.Time{}, false
+		}
+		if s, ok := raw.(string); ok {
+			t, err := parseDate(s)
+			return t, err == nil
+		}
+		if ms, ok := toFloat(raw); ok {
+			return time.Unix(0, int64(ms)*int64(time.Millisecond)).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseDate(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// compareValues orders two numbers, two strings or two dates. The second
+// result is false when the values are not comparable with each other.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := dateValue(a); ok {
+		y, ok := dateValue(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x.Before(y):
+			return -1, true
+		case x.After(y):
+			return 1, true
+		}
+		return 0, true
+	}
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	if x, ok := a.(string); ok {
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	}
+	return 0, false
+}
+
+// typeRank orders values of different JSON types the way MongoDB orders
+// BSON types: null < numbers < strings < objects < arrays < booleans <
+// dates.
+func typeRank(v interface{}) int {
+	if _, ok := toFloat(v); ok {
+		return 2
+	}
+	if _, ok := dateValue(v); ok {
+		return 7
+	}
+	switch v.(type) {
+	case nil:
+		return 1
+	case string:
+		return 3
+	case map[string]interface{}:
+		return 4
+	case []interface{}:
+		return 5
+	case bool:
+		return 6
+	}
+	return 8
+}
+
+// orderValues is a total order over decoded JSON values: values of
+// different types are ordered by typeRank, values of the same type by
+// their contents.
+func orderValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+
+	switch x := a.(type) {
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := orderValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	}
+
+	// Objects and anything else: encoding/json sorts map keys, so the
+	// encoding is a stable basis for comparison.
+	ea, _ := json.Marshal(a)
+	eb, _ := json.Marshal(b)
+	return bytes.Compare(ea, eb)
+}
+
+// valuesEqual reports whether two decoded JSON values are equal. Numbers
+// and dates are compared by value, arrays and objects element by element.
+func valuesEqual(a, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	if typeRank(a) != typeRank(b) {
+		return false
+	}
+
+	switch x := a.(type) {
+	case []interface{}:
+		y := b.([]interface{})
+		if len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valuesEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y := b.(map[string]interface{})
+		if len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !valuesEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// normalize round-trips v through JSON so that it only holds the types
+// produced by encoding/json (maps, slices, float64, string, bool, nil).
+func normalize(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// valueKey returns a string under which equal JSON values are equal, to
+// group or deduplicate them: their encoding, as numbers are float64 and
+// encoding/json sorts object keys.
+func valueKey(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
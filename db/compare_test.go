@@ -0,0 +1,70 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOrderValues(t *testing.T) {
+	// Each value sorts before every value that follows it.
+	ordered := []string{
+		`null`,
+		`-3`,
+		`2`,
+		`2.5`,
+		`"10"`,
+		`"2"`,
+		`"a"`,
+		`{"a": 1}`,
+		`{"b": 0}`,
+		`[1]`,
+		`[1, 2]`,
+		`[2]`,
+		`false`,
+		`true`,
+		`{"$date": "2024-01-02T15:04:05Z"}`,
+		`{"$date": 1704300000000}`,
+	}
+	values := make([]interface{}, len(ordered))
+	for i, s := range ordered {
+		if err := json.Unmarshal([]byte(s), &values[i]); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	for i := range values {
+		for j := range values {
+			got := orderValues(values[i], values[j])
+			switch {
+			case i < j && got >= 0, i > j && got <= 0, i == j && got != 0:
+				t.Errorf("orderValues(%s, %s) = %d", ordered[i], ordered[j], got)
+			}
+		}
+	}
+}
+
+func TestValuesEqual(t *testing.T) {
+	date := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		a, b interface{}
+		want bool
+	}{
+		{1, 1.0, true},
+		{int64(2), 2.0, true},
+		{"1", 1.0, false},
+		{nil, nil, true},
+		{nil, false, false},
+		{[]interface{}{1.0, "a"}, []interface{}{1, "a"}, true},
+		{[]interface{}{1.0}, []interface{}{1.0, 2.0}, false},
+		{map[string]interface{}{"a": 1}, map[string]interface{}{"a": 1.0}, true},
+		{map[string]interface{}{"a": 1}, map[string]interface{}{"b": 1}, false},
+		{date, map[string]interface{}{"$date": "2024-01-02T15:04:05Z"}, true},
+		{date, map[string]interface{}{"$date": 1704207845000.0}, true},
+		{date, map[string]interface{}{"$date": "2024-01-02T15:04:06Z"}, false},
+	}
+	for _, tt := range tests {
+		if got := valuesEqual(tt.a, tt.b); got != tt.want {
+			t.Errorf("valuesEqual(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
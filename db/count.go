@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Exists reports whether collection/resource is stored, without reading
+// the record.
+func (d *Driver) Exists(collection, resource string) (bool, error) {
+	if collection == "" || resource == "" {
+		return false, fmt.Errorf("Missing collection or resource - unable to check record!")
+	}
+	return d.exists(collection, resource)
+}
+
+// Count returns the number of records of collection that match query. An
+// empty query counts the record files without reading them, unless some
+// may have expired; otherwise only the candidates of an index lookup are
+// read when an index applies. A collection that does not exist has no
+// records.
+func (d *Driver) Count(collection string, query map[string]interface{}) (int, error) {
+	if collection == "" {
+		return 0, fmt.Errorf("Missing collection - unable to count")
+	}
+
+	if len(query) == 0 && d.expiry(collection) == nil {
+		resources, err := d.resources(collection)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return len(resources), err
+	}
+
+	n := 0
+	err := d.scan(collection, query, func(map[string]interface{}) {
+		n++
+	})
+	return n, err
+}
+
+// Distinct returns the distinct values of field among the records of
+// collection that match query, sorted like query comparisons order them.
+// Elements of array fields count as separate values and records without
+// the field contribute nothing.
+func (d *Driver) Distinct(collection, field string, query map[string]interface{}) ([]interface{}, error) {
+	if field == "" {
+		return nil, fmt.Errorf("Missing field - unable to collect distinct values!")
+	}
+
+	seen := make(map[string]bool)
+	values := []interface{}{}
+	err := d.scan(collection, query, func(record map[string]interface{}) {
+		for _, v := range fieldValues(record, field) {
+			items := []interface{}{v}
+			if arr, ok := v.([]interface{}); ok {
+				items = arr
+			}
+			for _, item := range items {
+				if k := valueKey(item); !seen[k] {
+					seen[k] = true
+					values = append(values, item)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return orderValues(values[i], values[j]) < 0
+	})
+	return values, nil
+}
+
+// scan calls fn for every record of collection that matches query.
+func (d *Driver) scan(collection string, query map[string]interface{}, fn func(record map[string]interface{})) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to search")
+	}
+
+	match, text, err := compileFilter(query)
+	if err != nil {
+		return err
+	}
+
+	resources, err := d.candidates(collection, query, text)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.eachRecord(collection, resources, nil, func(_ string, record map[string]interface{}) bool {
+		if match(record) {
+			fn(record)
+		}
+		return true
+	})
+}
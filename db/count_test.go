@@ -0,0 +1,114 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCountAndDistinct(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for name, doc := range map[string]string{
+		"1": `{"city": "Austin", "age": 30, "tags": ["a", "b"]}`,
+		"2": `{"city": "Boston", "age": 25, "tags": ["b"]}`,
+		"3": `{"city": "Austin", "age": "30", "tags": "c"}`,
+		"4": `{"age": 40, "address": {"zip": 1}}`,
+		"5": `{"city": null, "age": 35, "address": {"zip": 1.0}}`,
+	} {
+		if err := d.Write("people", name, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.CreateIndex("people", "city", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := []struct {
+		collection string
+		query      string
+		want       int
+	}{
+		{"people", `{}`, 5},
+		{"people", `{"city": "Austin"}`, 2},
+		{"people", `{"age": {"$gte": 30}}`, 3},
+		{"people", `{"tags": "b"}`, 2},
+		{"people", `{"city": {"$exists": false}}`, 1},
+		{"people", `{"city": "Denver"}`, 0},
+		{"missing", `{}`, 0},
+		{"missing", `{"a": 1}`, 0},
+	}
+	for _, tt := range counts {
+		n, err := d.Count(tt.collection, decode(t, tt.query))
+		if err != nil {
+			t.Fatalf("Count(%s, %s): %v", tt.collection, tt.query, err)
+		}
+		if n != tt.want {
+			t.Errorf("Count(%s, %s) = %d, want %d", tt.collection, tt.query, n, tt.want)
+		}
+	}
+
+	distincts := []struct {
+		field string
+		query string
+		want  string
+	}{
+		{"city", `{}`, `[null, "Austin", "Boston"]`},
+		{"city", `{"age": {"$lt": 35}}`, `["Austin", "Boston"]`},
+		{"age", `{}`, `[25, 30, 35, 40, "30"]`},
+		{"tags", `{}`, `["a", "b", "c"]`},
+		{"address.zip", `{}`, `[1]`},
+		{"address", `{}`, `[{"zip": 1}]`},
+		{"nothing", `{}`, `[]`},
+	}
+	for _, tt := range distincts {
+		values, err := d.Distinct("people", tt.field, decode(t, tt.query))
+		if err != nil {
+			t.Fatalf("Distinct(%s, %s): %v", tt.field, tt.query, err)
+		}
+		var want []interface{}
+		if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("Distinct(%s, %s) = %v, want %v", tt.field, tt.query, values, want)
+		}
+	}
+
+	if _, err := d.Count("people", decode(t, `{"age": {"$bad": 1}}`)); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Count with a bad query: err = %v, want ErrInvalidQuery", err)
+	}
+	if _, err := d.Distinct("people", "city", decode(t, `{"$or": 1}`)); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("Distinct with a bad query: err = %v, want ErrInvalidQuery", err)
+	}
+	if _, err := d.Distinct("people", "", nil); err == nil {
+		t.Error("Distinct without a field succeeded")
+	}
+}
+
+func TestExists(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("c", "r", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		collection, resource string
+		want                 bool
+	}{
+		{"c", "r", true},
+		{"c", "other", false},
+		{"missing", "r", false},
+	} {
+		got, err := d.Exists(tt.collection, tt.resource)
+		if err != nil {
+			t.Fatalf("Exists(%s, %s): %v", tt.collection, tt.resource, err)
+		}
+		if got != tt.want {
+			t.Errorf("Exists(%s, %s) = %v, want %v", tt.collection, tt.resource, got, tt.want)
+		}
+	}
+	if _, err := d.Exists("c", ""); err == nil {
+		t.Error("Exists without a resource succeeded")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,10 +10,22 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jcelliott/lumber"
 )
 
+var (
+	// ErrNotFound is returned when a resource does not exist in its collection.
+	ErrNotFound = errors.New("resource not found")
+	// ErrAlreadyExists is returned by an insert-only write when the resource
+	// is already present.
+	ErrAlreadyExists = errors.New("resource already exists")
+	// ErrRevisionMismatch is returned by WriteIf when the stored revision is
+	// not the one the caller expected.
+	ErrRevisionMismatch = errors.New("revision mismatch")
+)
+
 type Logger interface {
 	Fatal(string, ...interface{})
 	Error(string, ...interface{})
@@ -27,10 +40,53 @@ type Driver struct {
 	mutexes map[string]*sync.Mutex
 	dir     string
 	log     Logger
+	wal     *wal
+	ckpt    sync.RWMutex // held shared by commits, exclusively by checkpoints
+
+	catalogMu sync.Mutex
+	colls     map[string]*collState
+
+	reaperStop chan struct{} // closed to stop the reaper
+	reaperDone chan struct{} // closed when the reaper has stopped
+
+	metadata bool // maintain _created, _updated and _rev in documents
 }
 
 type Options struct {
 	Logger
+	// Durability controls when writes are flushed to disk. The default,
+	// DurabilityNone, never fsyncs.
+	Durability Durability
+	// ReapInterval is how often expired documents are deleted in the
+	// background; zero means once a minute and a negative interval turns
+	// the reaper off. Expired documents are hidden from reads either way.
+	ReapInterval time.Duration
+	// Metadata makes every write maintain "_created", "_updated" (RFC 3339
+	// times) and "_rev" (the number of times the document has been
+	// written) in the documents it stores. "_id", the resource name, is
+	// stored either way.
+	Metadata bool
+}
+
+// WriteMode controls how a write treats an existing resource.
+type WriteMode int
+
+const (
+	// Upsert creates the resource or replaces it if it already exists.
+	Upsert WriteMode = iota
+	// InsertOnly fails with ErrAlreadyExists if the resource exists.
+	InsertOnly
+	// ReplaceOnly fails with ErrNotFound if the resource does not exist.
+	ReplaceOnly
+)
+
+// WriteOptions tunes a single call to WriteWith.
+type WriteOptions struct {
+	Mode WriteMode
+	// ExpiresAt, when set, makes the document expire at that time. It is
+	// stored in the document as "_expires", so v must encode to a JSON
+	// object.
+	ExpiresAt time.Time
 }
 
 func New(dir string, options *Options) (*Driver, error) {
@@ -44,19 +100,45 @@ func New(dir string, options *Options) (*Driver, error) {
 	}
 
 	driver := Driver{
-		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
-		log:     opts.Logger,
+		dir:      dir,
+		mutexes:  make(map[string]*sync.Mutex),
+		log:      opts.Logger,
+		metadata: opts.Metadata,
+		colls:    make(map[string]*collState),
 	}
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using '%s' (database already exists)\n", dir)
-		return &driver, nil
+	} else {
+		opts.Logger.Debug("Creating the database at '%s'...\n", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return &driver, err
+		}
+	}
+	if err := driver.openWAL(opts.Durability); err != nil {
+		return &driver, err
+	}
+
+	if opts.ReapInterval == 0 {
+		opts.ReapInterval = defaultReapInterval
 	}
-	opts.Logger.Debug("Creating the database at '%s'...\n", dir)
-	return &driver, os.MkdirAll(dir, 0755)
+	if opts.ReapInterval > 0 {
+		driver.startReaper(opts.ReapInterval)
+	}
+	return &driver, nil
 }
 
+// Write creates or replaces collection/resource with v.
 func (d *Driver) Write(collection, resource string, v interface{}) error {
+	return d.WriteWith(collection, resource, v, nil)
+}
+
+// WriteWith stores v as collection/resource, honouring the write mode in
+// opts. A nil opts behaves like Write.
+func (d *Driver) WriteWith(collection, resource string, v interface{}, opts *WriteOptions) error {
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+
 	if collection == "" {
 		return fmt.Errorf("Missing collection - no place to save record!")
 	}
@@ -69,6 +151,87 @@ func (d *Driver) Write(collection, resource string, v interface{}) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	if !opts.ExpiresAt.IsZero() {
+		doc, err := d.withExpiry(collection, v, opts.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		v = doc
+	}
+
+	if opts.Mode != Upsert {
+		exists, err := d.exists(collection, resource)
+		if err != nil {
+			return err
+		}
+		if opts.Mode == InsertOnly && exists {
+			return fmt.Errorf("%w: %s/%s", ErrAlreadyExists, collection, resource)
+		}
+		if opts.Mode == ReplaceOnly && !exists {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, collection, resource)
+		}
+	}
+
+	_, err := d.write(collection, resource, v)
+	return err
+}
+
+// exists reports whether collection/resource is stored on disk and has
+// not expired.
+func (d *Driver) exists(collection, resource string) (bool, error) {
+	if d.expiry(collection) != nil {
+		_, err := d.readRaw(collection, resource)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	_, err := os.Stat(filepath.Join(d.dir, collection, resource+".json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// write stores v as collection/resource and returns its new revision. The
+// caller must hold the collection mutex.
+func (d *Driver) write(collection, resource string, v interface{}) (string, error) {
+	b, err := d.store(collection, resource, v)
+	if err != nil {
+		return "", err
+	}
+	return revision(b), nil
+}
+
+// store is write returning the stored record, which carries the fields
+// the driver maintains as well as those of v.
+func (d *Driver) store(collection, resource string, v interface{}) ([]byte, error) {
+	b, err := encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []walOp{{Kind: opWrite, Collection: collection, Resource: resource, Data: b}}
+	if err := d.commit(ops); err != nil {
+		return nil, err
+	}
+	return ops[0].Data, nil
+}
+
+// encode renders v the way records are stored on disk.
+func encode(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, byte('\n')), nil
+}
+
+// writeBytes atomically replaces collection/resource with an encoded
+// record.
+func (d *Driver) writeBytes(collection, resource string, b []byte) error {
 	dir := filepath.Join(d.dir, collection)
 	fnlPath := filepath.Join(dir, resource+".json")
 	tmpPath := fnlPath + ".tmp"
@@ -77,18 +240,15 @@ func (d *Driver) Write(collection, resource string, v interface{}) error {
 		return err
 	}
 
-	b, err := json.MarshalIndent(v, "", "\t")
-	if err != nil {
+	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
 		return err
 	}
 
-	b = append(b, byte('\n'))
-
-	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+	if err := os.Rename(tmpPath, fnlPath); err != nil {
 		return err
 	}
-
-	return os.Rename(tmpPath, fnlPath)
+	d.wal.touch(fnlPath, dir, d.dir)
+	return nil
 }
 
 func (d *Driver) Read(collection, resource string, v interface{}) error {
@@ -100,48 +260,131 @@ func (d *Driver) Read(collection, resource string, v interface{}) error {
 		return fmt.Errorf("Missing resource - unable to read record (no name)!")
 	}
 
-	record := filepath.Join(d.dir, collection, resource+".json")
-
-	if _, err := stat(record); err != nil {
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
 		return err
 	}
 
-	b, err := ioutil.ReadFile(record)
+	return json.Unmarshal(rawWithID(b, resource), v)
+}
+
+// readRaw returns the stored bytes of collection/resource, which is not
+// found once it has expired.
+func (d *Driver) readRaw(collection, resource string) ([]byte, error) {
+	b, err := d.readStored(collection, resource)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if d.expiry(collection).expiredRaw(b) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, collection, resource)
+	}
+	return b, nil
+}
+
+// readStored is readRaw including expired records.
+func (d *Driver) readStored(collection, resource string) ([]byte, error) {
+	record := filepath.Join(d.dir, collection, resource+".json")
+
+	if _, err := stat(record); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, collection, resource)
+		}
+		return nil, err
 	}
 
-	return json.Unmarshal(b, v)
+	return ioutil.ReadFile(record)
 }
 
 func (d *Driver) ReadAll(collection string) ([]json.RawMessage, error) {
+	records, _, err := d.ReadAllWith(collection, nil)
+	return records, err
+}
+
+// ReadAllWith reads the documents of collection, sorted, projected and
+// paged according to opts. A nil opts returns every document as stored,
+// ordered by resource name. Without a sort, the documents of a capped
+// collection come in insertion order. The returned cursor is set when
+// opts.Limit cut the result short; passing it back as opts.Cursor reads
+// the next page.
+func (d *Driver) ReadAllWith(collection string, opts *FindOptions) ([]json.RawMessage, string, error) {
 	if collection == "" {
-		return nil, fmt.Errorf("Missing collection - unable to read")
+		return nil, "", fmt.Errorf("Missing collection - unable to read")
 	}
 	dir := filepath.Join(d.dir, collection)
 
 	d.log.Debug("Checking directory: %s", dir)
 	if _, err := stat(dir); err != nil {
 		d.log.Error("Directory check error: %s", err)
-		return nil, err
+		return nil, "", err
 	}
 
-	files, err := ioutil.ReadDir(dir)
+	exp := d.expiry(collection)
+	order, capped := d.insertionOrder(collection)
+	if opts == nil {
+		resources := order
+		var err error
+		if !capped {
+			resources, err = d.resources(collection)
+		}
+		if err != nil {
+			d.log.Error("Read directory error: %s", err)
+			return nil, "", err
+		}
+
+		var records []json.RawMessage
+		for _, resource := range resources {
+			b, err := ioutil.ReadFile(filepath.Join(dir, resource+".json"))
+			if os.IsNotExist(err) {
+				// Deleted since the resources were listed.
+				continue
+			}
+			if err != nil {
+				d.log.Error("Read file error: %s", err)
+				return nil, "", err
+			}
+			if exp.expiredRaw(b) {
+				continue
+			}
+			records = append(records, json.RawMessage(rawWithID(b, resource)))
+		}
+		return records, "", nil
+	}
+
+	found, err := newFinder(*opts)
 	if err != nil {
-		d.log.Error("Read directory error: %s", err)
-		return nil, err
+		return nil, "", err
 	}
 
-	var records []json.RawMessage
-	for _, file := range files {
-		b, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+	var resources []string
+	if capped && len(opts.Sort) == 0 {
+		if resources, err = found.remainingUnsorted(collection, order); err != nil {
+			return nil, "", err
+		}
+	} else {
+		if resources, err = d.resources(collection); err != nil {
+			d.log.Error("Read directory error: %s", err)
+			return nil, "", err
+		}
+		resources = found.remaining(collection, resources)
+	}
+	err = d.eachRecord(collection, resources, nil, func(resource string, doc map[string]interface{}) bool {
+		return found.add(Record{Collection: collection, Resource: resource}, doc, true)
+	})
+	if err != nil {
+		d.log.Error("Read file error: %s", err)
+		return nil, "", err
+	}
+
+	recs, next := found.records()
+	records := make([]json.RawMessage, 0, len(recs))
+	for _, rec := range recs {
+		b, err := json.Marshal(rec.Document)
 		if err != nil {
-			d.log.Error("Read file error: %s", err)
-			return nil, err
+			return nil, "", err
 		}
 		records = append(records, json.RawMessage(b))
 	}
-	return records, nil
+	return records, next, nil
 }
 
 func (d *Driver) Delete(collection, resource string) error {
@@ -157,10 +400,10 @@ func (d *Driver) Delete(collection, resource string) error {
 		return fmt.Errorf("Unable to find file or directory named %v\n", path)
 
 	case fi.Mode().IsDir():
-		return os.RemoveAll(dir)
+		return d.commit([]walOp{{Kind: opDeleteAll, Collection: path}})
 
 	case fi.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+		return d.commit([]walOp{{Kind: opDelete, Collection: collection, Resource: resource}})
 	}
 	return nil
 }
@@ -200,99 +443,13 @@ func (d *Driver) DeleteAll(collection string) error {
 		return fmt.Errorf("Unable to find directory named %v\n", dir)
 	case fi.Mode().IsDir():
 		d.log.Debug("Deleting directory: %s", dir)
-		return os.RemoveAll(dir)
+		return d.commit([]walOp{{Kind: opDeleteAll, Collection: collection}})
 	default:
 		d.log.Error("Invalid file mode: %s", dir)
 		return fmt.Errorf("Invalid file mode for %v\n", dir)
 	}
 }
 
-func (d *Driver) Search(query map[string]interface{}) (map[string][]string, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
-	results := make(map[string][]string)
-	err := filepath.Walk(d.dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && filepath.Ext(path) == ".json" {
-			content, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
-			}
-
-			var record map[string]interface{}
-			if err := json.Unmarshal(content, &record); err != nil {
-				return err
-			}
-
-			if matchesQuery(record, query) {
-				collection := filepath.Base(filepath.Dir(path))
-				resource := filepath.Base(path)
-				resource = resource[:len(resource)-len(filepath.Ext(resource))]
-				results[collection] = append(results[collection], resource)
-			}
-		}
-		return nil
-	})
-
-	return results, err
-}
-
-func matchesQuery(record, query map[string]interface{}) bool {
-	for key, value := range query {
-		if recordValue, ok := record[key]; ok {
-			switch value := value.(type) {
-			case map[string]interface{}: // Handling nested queries like { "age": { "$gt": 25 } }
-				for op, v := range value {
-					switch op {
-					case "$gt":
-						if recordValue.(float64) <= v.(float64) {
-							return false
-						}
-					case "$lt":
-						if recordValue.(float64) >= v.(float64) {
-							return false
-						}
-					case "$gte":
-						if recordValue.(float64) < v.(float64) {
-							return false
-						}
-					case "$lte":
-						if recordValue.(float64) > v.(float64) {
-							return false
-						}
-					case "$ne":
-						if recordValue == v {
-							return false
-						}
-					case "$in":
-						found := false
-						for _, item := range v.([]interface{}) {
-							if recordValue == item {
-								found = true
-								break
-							}
-						}
-						if !found {
-							return false
-						}
-					}
-				}
-			default:
-				if recordValue != value {
-					return false
-				}
-			}
-		} else {
-			return false
-		}
-	}
-	return true
-}
-
 func containsKeyword(content, keyword string) bool {
 	return strings.Contains(content, keyword)
 }
@@ -302,6 +459,11 @@ func (d *Driver) RegexSearch(collection string, query map[string]string) ([]map[
 		return nil, fmt.Errorf("Missing collection - unable to search")
 	}
 
+	match, err := compileRegexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
 	dir := filepath.Join(d.dir, collection)
 	d.log.Debug("Checking directory: %s", dir)
 	if _, err := stat(dir); err != nil {
@@ -315,8 +477,12 @@ func (d *Driver) RegexSearch(collection string, query map[string]string) ([]map[
 		return nil, err
 	}
 
+	exp := d.expiry(collection)
 	var records []map[string]interface{}
 	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
 		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
 		if err != nil {
 			d.log.Error("Read file error: %s", err)
@@ -329,24 +495,29 @@ func (d *Driver) RegexSearch(collection string, query map[string]string) ([]map[
 			continue
 		}
 
-		if matchesRegex(record, query) {
+		withID(record, strings.TrimSuffix(file.Name(), ".json"))
+		if match(record) && !exp.expired(record) {
 			records = append(records, record)
 		}
 	}
 	return records, nil
 }
 
-func matchesRegex(record map[string]interface{}, query map[string]string) bool {
+// compileRegexQuery compiles the patterns of a RegexSearch query once, so
+// that a bad pattern is reported before any record is read.
+func compileRegexQuery(query map[string]string) (matcher, error) {
+	parts := make([]matcher, 0, len(query))
 	for key, pattern := range query {
-		if value, ok := record[key]; ok {
-			strValue := fmt.Sprintf("%v", value)
-			matched, err := regexp.MatchString(pattern, strValue)
-			if err != nil || !matched {
-				return false
-			}
-		} else {
-			return false
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, invalidQuery("field %q: %v", key, err)
 		}
+		key := key
+		parts = append(parts, func(record map[string]interface{}) bool {
+			return anyValue(fieldValues(record, key), func(v interface{}) bool {
+				return re.MatchString(fmt.Sprintf("%v", v))
+			})
+		})
 	}
-	return true
+	return allOf(parts), nil
 }
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestDriver opens a driver on a fresh temporary directory and closes
+// it when the test ends.
+func newTestDriver(t *testing.T, opts *Options) *Driver {
+	t.Helper()
+	d, err := New(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestWriteModes(t *testing.T) {
+	tests := []struct {
+		name   string
+		exists bool
+		mode   WriteMode
+		err    error
+	}{
+		{"upsert new", false, Upsert, nil},
+		{"upsert existing", true, Upsert, nil},
+		{"insert new", false, InsertOnly, nil},
+		{"insert existing", true, InsertOnly, ErrAlreadyExists},
+		{"replace new", false, ReplaceOnly, ErrNotFound},
+		{"replace existing", true, ReplaceOnly, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDriver(t, nil)
+			if tt.exists {
+				if err := d.Write("c", "r", map[string]interface{}{"v": "old"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := d.WriteWith("c", "r", map[string]interface{}{"v": "new"}, &WriteOptions{Mode: tt.mode})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			var doc map[string]interface{}
+			err = d.Read("c", "r", &doc)
+			switch {
+			case tt.err == nil && doc["v"] != "new":
+				t.Errorf("stored %v after a successful write", doc)
+			case tt.err != nil && tt.exists && doc["v"] != "old":
+				t.Errorf("stored %v after a rejected write", doc)
+			case tt.err != nil && !tt.exists && !errors.Is(err, ErrNotFound):
+				t.Errorf("rejected write created the resource: %v", doc)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import "time"
+
+// Explain describes how Search executed a query, in the spirit of
+// MongoDB's explain("executionStats").
+type Explain struct {
+	// Collections reports the plan chosen for every searched collection.
+	Collections   []CollectionExplain `json:"collections"`
+	FilesExamined int                 `json:"filesExamined"`
+	DocsParsed    int                 `json:"docsParsed"`
+	DocsMatched   int                 `json:"docsMatched"`
+	DocsReturned  int                 `json:"docsReturned"`
+	// Stages lists the time spent in each stage of the execution: PLAN
+	// (choosing an index and listing candidates), FETCH (reading record
+	// files), PARSE (decoding them), MATCH (evaluating the query) and
+	// COLLECT (sorting, projecting and paging).
+	Stages []StageExplain `json:"stages"`
+	Millis float64        `json:"executionTimeMillis"`
+}
+
+// CollectionExplain is the plan and the work done for one collection.
+type CollectionExplain struct {
+	Collection string `json:"collection"`
+	// Plan is COLLSCAN when every record is examined, or IXSCAN when the
+	// candidates come from Index.
+	Plan          string `json:"plan"`
+	Index         string `json:"index,omitempty"`
+	Candidates    int    `json:"candidates"`
+	FilesExamined int    `json:"filesExamined"`
+	DocsParsed    int    `json:"docsParsed"`
+	DocsMatched   int    `json:"docsMatched"`
+}
+
+// StageExplain is the time spent in one stage of a search.
+type StageExplain struct {
+	Stage  string  `json:"stage"`
+	Millis float64 `json:"executionTimeMillis"`
+}
+
+const (
+	stagePlan = iota
+	stageFetch
+	stageParse
+	stageMatch
+	stageCollect
+	numStages
+)
+
+var stageNames = [numStages]string{"PLAN", "FETCH", "PARSE", "MATCH", "COLLECT"}
+
+// explainer gathers the statistics of an Explain while a search runs. A
+// nil explainer, used when explain mode is off, ignores every call.
+type explainer struct {
+	Explain
+	start time.Time
+	spent [numStages]time.Duration
+}
+
+func newExplainer(enabled bool) *explainer {
+	if !enabled {
+		return nil
+	}
+	return &explainer{start: time.Now()}
+}
+
+// clock returns the start time of a measurement.
+func (x *explainer) clock() time.Time {
+	if x == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// spend charges the time since start to stage.
+func (x *explainer) spend(stage int, start time.Time) {
+	if x != nil {
+		x.spent[stage] += time.Since(start)
+	}
+}
+
+// plan records the plan chosen for the next collection.
+func (x *explainer) plan(collection, index string, candidates int) {
+	if x == nil {
+		return
+	}
+	c := CollectionExplain{Collection: collection, Plan: "COLLSCAN", Index: index, Candidates: candidates}
+	if index != "" {
+		c.Plan = "IXSCAN"
+	}
+	x.Collections = append(x.Collections, c)
+}
+
+func (x *explainer) examined() {
+	if x != nil {
+		x.FilesExamined++
+		x.Collections[len(x.Collections)-1].FilesExamined++
+	}
+}
+
+func (x *explainer) parsed() {
+	if x != nil {
+		x.DocsParsed++
+		x.Collections[len(x.Collections)-1].DocsParsed++
+	}
+}
+
+func (x *explainer) matched() {
+	if x != nil {
+		x.DocsMatched++
+		x.Collections[len(x.Collections)-1].DocsMatched++
+	}
+}
+
+// finish completes the statistics once returned records were produced.
+func (x *explainer) finish(returned int) *Explain {
+	if x == nil {
+		return nil
+	}
+	x.DocsReturned = returned
+	for stage, d := range x.spent {
+		x.Stages = append(x.Stages, StageExplain{Stage: stageNames[stage], Millis: millis(d)})
+	}
+	x.Millis = millis(time.Since(x.start))
+	if x.Collections == nil {
+		x.Collections = []CollectionExplain{}
+	}
+	return &x.Explain
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, collection := range []string{"indexed", "plain"} {
+		for resource, doc := range map[string]string{
+			"r1": `{"n": 1}`,
+			"r2": `{"n": 2}`,
+			"r3": `{"n": 2}`,
+			"r4": `{"n": 3}`,
+		} {
+			if err := d.Write(collection, resource, json.RawMessage(doc)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := d.CreateIndex("indexed", "n", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		opts     SearchOptions
+		plans    []CollectionExplain
+		returned int
+	}{
+		{
+			name:  "index scan",
+			query: `{"n": 2}`,
+			opts:  SearchOptions{Collections: []string{"indexed"}},
+			plans: []CollectionExplain{
+				{Collection: "indexed", Plan: "IXSCAN", Index: "n", Candidates: 2, FilesExamined: 2, DocsParsed: 2, DocsMatched: 2},
+			},
+			returned: 2,
+		},
+		{
+			name:  "collection scan",
+			query: `{"n": 2}`,
+			opts:  SearchOptions{Collections: []string{"plain"}},
+			plans: []CollectionExplain{
+				{Collection: "plain", Plan: "COLLSCAN", Candidates: 4, FilesExamined: 4, DocsParsed: 4, DocsMatched: 2},
+			},
+			returned: 2,
+		},
+		{
+			name:  "both collections",
+			query: `{"n": {"$gte": 2}}`,
+			plans: []CollectionExplain{
+				{Collection: "indexed", Plan: "IXSCAN", Index: "n", Candidates: 3, FilesExamined: 3, DocsParsed: 3, DocsMatched: 3},
+				{Collection: "plain", Plan: "COLLSCAN", Candidates: 4, FilesExamined: 4, DocsParsed: 4, DocsMatched: 3},
+			},
+			returned: 6,
+		},
+		{
+			// The scan stops once it knows there is a record past the limit.
+			name:  "limit",
+			query: `{"n": {"$gte": 2}}`,
+			opts:  SearchOptions{FindOptions: FindOptions{Limit: 1}},
+			plans: []CollectionExplain{
+				{Collection: "indexed", Plan: "IXSCAN", Index: "n", Candidates: 3, FilesExamined: 2, DocsParsed: 2, DocsMatched: 2},
+			},
+			returned: 1,
+		},
+		{
+			name:     "missing collection",
+			query:    `{}`,
+			opts:     SearchOptions{Collections: []string{"missing"}},
+			plans:    []CollectionExplain{},
+			returned: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Explain = true
+			res, err := d.Search(decode(t, tt.query), &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ex := res.Explain
+			if ex == nil {
+				t.Fatal("no explain")
+			}
+			if len(ex.Collections) != len(tt.plans) {
+				t.Fatalf("collections %+v, want %+v", ex.Collections, tt.plans)
+			}
+			var examined, parsed, matched int
+			for i, plan := range tt.plans {
+				if ex.Collections[i] != plan {
+					t.Errorf("collection %d: %+v, want %+v", i, ex.Collections[i], plan)
+				}
+				examined += plan.FilesExamined
+				parsed += plan.DocsParsed
+				matched += plan.DocsMatched
+			}
+			if ex.FilesExamined != examined || ex.DocsParsed != parsed || ex.DocsMatched != matched {
+				t.Errorf("totals %d/%d/%d, want %d/%d/%d",
+					ex.FilesExamined, ex.DocsParsed, ex.DocsMatched, examined, parsed, matched)
+			}
+			if ex.DocsReturned != tt.returned || len(res.Records) != tt.returned {
+				t.Errorf("returned %d with %d records, want %d", ex.DocsReturned, len(res.Records), tt.returned)
+			}
+			if len(ex.Stages) != numStages {
+				t.Fatalf("stages %+v", ex.Stages)
+			}
+			for i, stage := range ex.Stages {
+				if stage.Stage != stageNames[i] || stage.Millis < 0 {
+					t.Errorf("stage %d: %+v", i, stage)
+				}
+			}
+		})
+	}
+
+	res, err := d.Search(decode(t, `{"n": 2}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Explain != nil {
+		t.Errorf("explain without asking for it: %+v", res.Explain)
+	}
+}
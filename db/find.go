@@ -0,0 +1,413 @@
+package db
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// SortField orders documents by the value at a dot-separated path.
+type SortField struct {
+	Path string
+	Desc bool
+}
+
+// FindOptions sorts, trims and pages the documents returned by Search and
+// ReadAllWith.
+type FindOptions struct {
+	// Sort orders documents by the given fields in turn. Ties, and all
+	// documents when Sort is empty, are ordered by collection and then by
+	// resource name.
+	Sort []SortField
+	// Projection maps field paths to true to return only those fields, or
+	// to false to return everything but them. The two cannot be mixed,
+	// except that, as in MongoDB, "_id" is returned with the included
+	// fields unless it is excluded.
+	Projection map[string]bool
+	// Skip drops this many documents from the start of the result.
+	Skip int
+	// Limit caps the number of documents returned; 0 means no limit. When
+	// more documents follow, the result carries a cursor to continue from.
+	Limit int
+	// Cursor continues a previous call right after the last record it
+	// returned. Records are visited in collection and resource order, so
+	// paging neither repeats nor misses records that exist throughout,
+	// even while other records are written or deleted. A cursor cannot be
+	// combined with Sort.
+	Cursor string
+}
+
+func (o *FindOptions) validate() error {
+	if o.Cursor != "" && len(o.Sort) > 0 {
+		return invalidQuery("a cursor cannot be combined with sort")
+	}
+	if o.Skip < 0 || o.Limit < 0 {
+		return invalidQuery("skip and limit must not be negative")
+	}
+	for _, f := range o.Sort {
+		if f.Path == "" {
+			return invalidQuery("sort field must not be empty")
+		}
+	}
+	include, exclude := 0, 0
+	for path, keep := range o.Projection {
+		if path == "" {
+			return invalidQuery("projection field must not be empty")
+		}
+		switch {
+		case path == idField:
+		case keep:
+			include++
+		default:
+			exclude++
+		}
+	}
+	if include > 0 && exclude > 0 {
+		return invalidQuery("projection cannot mix included and excluded fields")
+	}
+	return nil
+}
+
+// inclusive reports whether a valid projection lists the fields to keep
+// rather than those to drop.
+func inclusive(projection map[string]bool) bool {
+	for path, keep := range projection {
+		if keep && (path != idField || len(projection) == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKey returns the value a document is sorted by for f. Like MongoDB,
+// an array field sorts by its smallest element in ascending order and by
+// its largest in descending order; a missing field sorts as null.
+func sortKey(doc map[string]interface{}, f SortField) interface{} {
+	var (
+		key   interface{}
+		found bool
+	)
+	consider := func(v interface{}) {
+		if !found {
+			key, found = v, true
+			return
+		}
+		c := orderValues(v, key)
+		if (f.Desc && c > 0) || (!f.Desc && c < 0) {
+			key = v
+		}
+	}
+
+	for _, v := range fieldValues(doc, f.Path) {
+		if arr, ok := v.([]interface{}); ok && len(arr) > 0 {
+			for _, elem := range arr {
+				consider(elem)
+			}
+			continue
+		}
+		consider(v)
+	}
+	return key
+}
+
+// sortItem is a record together with its precomputed sort keys.
+type sortItem struct {
+	rec  Record
+	keys []interface{}
+}
+
+// sorter orders records by a list of sort fields.
+type sorter struct {
+	fields []SortField
+}
+
+func (s sorter) item(rec Record, doc map[string]interface{}) sortItem {
+	keys := make([]interface{}, len(s.fields))
+	for i, f := range s.fields {
+		keys[i] = sortKey(doc, f)
+	}
+	return sortItem{rec: rec, keys: keys}
+}
+
+func (s sorter) less(a, b sortItem) bool {
+	for i, f := range s.fields {
+		c := orderValues(a.keys[i], b.keys[i])
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	if a.rec.Collection != b.rec.Collection {
+		return a.rec.Collection < b.rec.Collection
+	}
+	return a.rec.Resource < b.rec.Resource
+}
+
+// topK keeps the k records that sort first. It is a heap with the record
+// that sorts last at the top, so that a full heap can drop it in
+// O(log k) when a better record arrives and memory stays bounded by k.
+type topK struct {
+	sorter
+	k     int
+	items []sortItem
+}
+
+func (t *topK) Len() int           { return len(t.items) }
+func (t *topK) Less(i, j int) bool { return t.less(t.items[j], t.items[i]) }
+func (t *topK) Swap(i, j int)      { t.items[i], t.items[j] = t.items[j], t.items[i] }
+func (t *topK) Push(x interface{}) { t.items = append(t.items, x.(sortItem)) }
+func (t *topK) Pop() interface{} {
+	last := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	return last
+}
+
+// add offers an item to the heap. With k == 0 every item is kept.
+func (t *topK) add(it sortItem) {
+	if t.k > 0 && len(t.items) == t.k {
+		if !t.less(it, t.items[0]) {
+			return
+		}
+		t.items[0] = it
+		heap.Fix(t, 0)
+		return
+	}
+	heap.Push(t, it)
+}
+
+// sorted drains the heap and returns its records in order.
+func (t *topK) sorted() []Record {
+	recs := make([]Record, len(t.items))
+	for i := len(recs) - 1; i >= 0; i-- {
+		recs[i] = heap.Pop(t).(sortItem).rec
+	}
+	return recs
+}
+
+// page applies skip and limit to records that are already in order.
+func page(recs []Record, skip, limit int) []Record {
+	if skip >= len(recs) {
+		return nil
+	}
+	recs = recs[skip:]
+	if limit > 0 && limit < len(recs) {
+		recs = recs[:limit]
+	}
+	return recs
+}
+
+// project returns doc shaped by a projection. doc is modified in place
+// when fields are excluded.
+func project(doc map[string]interface{}, projection map[string]bool) map[string]interface{} {
+	if len(projection) == 0 {
+		return doc
+	}
+
+	if !inclusive(projection) {
+		for path, keep := range projection {
+			if !keep {
+				excludePath(doc, strings.Split(path, "."))
+			}
+		}
+		return doc
+	}
+
+	out := make(map[string]interface{})
+	for path, keep := range projection {
+		if keep {
+			includePath(out, doc, strings.Split(path, "."))
+		}
+	}
+	if keep, ok := projection[idField]; !ok || keep {
+		includePath(out, doc, []string{idField})
+	}
+	return out
+}
+
+// includePath copies the value at parts from src into dst. A path through
+// an array of objects is applied to every object element.
+func includePath(dst, src map[string]interface{}, parts []string) {
+	v, ok := src[parts[0]]
+	if !ok {
+		return
+	}
+	if len(parts) == 1 {
+		dst[parts[0]] = v
+		return
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		sub, _ := dst[parts[0]].(map[string]interface{})
+		if sub == nil {
+			sub = make(map[string]interface{})
+		}
+		includePath(sub, x, parts[1:])
+		dst[parts[0]] = sub
+	case []interface{}:
+		var objects []map[string]interface{}
+		for _, elem := range x {
+			if m, ok := elem.(map[string]interface{}); ok {
+				objects = append(objects, m)
+			}
+		}
+		arr, _ := dst[parts[0]].([]interface{})
+		if arr == nil {
+			arr = make([]interface{}, len(objects))
+			for i := range arr {
+				arr[i] = make(map[string]interface{})
+			}
+		}
+		for i, m := range objects {
+			includePath(arr[i].(map[string]interface{}), m, parts[1:])
+		}
+		dst[parts[0]] = arr
+	}
+}
+
+// excludePath removes the value at parts from doc, descending into arrays
+// of objects like includePath.
+func excludePath(doc map[string]interface{}, parts []string) {
+	if len(parts) == 1 {
+		delete(doc, parts[0])
+		return
+	}
+
+	switch x := doc[parts[0]].(type) {
+	case map[string]interface{}:
+		excludePath(x, parts[1:])
+	case []interface{}:
+		for _, elem := range x {
+			if m, ok := elem.(map[string]interface{}); ok {
+				excludePath(m, parts[1:])
+			}
+		}
+	}
+}
+
+// position is what a cursor encodes: the last record of a page.
+type position struct {
+	Collection string `json:"c"`
+	Resource   string `json:"r"`
+}
+
+func encodeCursor(rec Record) string {
+	b, _ := json.Marshal(position{Collection: rec.Collection, Resource: rec.Resource})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (*position, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidQuery("malformed cursor")
+	}
+	var pos position
+	if err := json.Unmarshal(b, &pos); err != nil || pos.Collection == "" {
+		return nil, invalidQuery("malformed cursor")
+	}
+	return &pos, nil
+}
+
+// finder collects the records of a scan, which must arrive ordered by
+// collection and resource name, and applies FindOptions to them. Without
+// a sort it stops the scan as soon as the requested page is complete;
+// with one it keeps only the best Skip+Limit records.
+type finder struct {
+	opts  FindOptions
+	after *position
+	top   *topK
+	recs  []Record
+}
+
+func newFinder(opts FindOptions) (*finder, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	f := &finder{opts: opts}
+	if opts.Cursor != "" {
+		var err error
+		if f.after, err = decodeCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	if len(opts.Sort) > 0 {
+		k := 0
+		if opts.Limit > 0 {
+			k = opts.Skip + opts.Limit
+		}
+		f.top = &topK{sorter: sorter{fields: opts.Sort}, k: k}
+	}
+	return f, nil
+}
+
+// remaining drops the sorted resources of collection that precede the
+// cursor or are the record it points at.
+func (f *finder) remaining(collection string, resources []string) []string {
+	switch {
+	case f.after == nil || collection > f.after.Collection:
+		return resources
+	case collection < f.after.Collection:
+		return nil
+	}
+	i := sort.SearchStrings(resources, f.after.Resource)
+	if i < len(resources) && resources[i] == f.after.Resource {
+		i++
+	}
+	return resources[i:]
+}
+
+// remainingUnsorted is remaining for resources that are not sorted by
+// name, such as the insertion order of a capped collection. The record
+// the cursor points at must still be there, as it holds the position.
+func (f *finder) remainingUnsorted(collection string, resources []string) ([]string, error) {
+	if f.after == nil || f.after.Collection != collection {
+		return resources, nil
+	}
+	for i, resource := range resources {
+		if resource == f.after.Resource {
+			return resources[i+1:], nil
+		}
+	}
+	return nil, invalidQuery("cursor position lost: %s has been deleted", f.after.Resource)
+}
+
+// add offers a matching record; doc is its full document and keep tells
+// whether the (projected) document is returned. add reports whether the
+// scan should continue.
+func (f *finder) add(rec Record, doc map[string]interface{}, keep bool) bool {
+	var it sortItem
+	if f.top != nil {
+		it = f.top.item(rec, doc)
+	}
+	if keep {
+		rec.Document = project(doc, f.opts.Projection)
+		it.rec = rec
+	}
+
+	if f.top != nil {
+		f.top.add(it)
+		return true
+	}
+	f.recs = append(f.recs, rec)
+	// One record past the page tells whether another page follows.
+	return f.opts.Limit == 0 || len(f.recs) <= f.opts.Skip+f.opts.Limit
+}
+
+// records returns the requested page and, when more records follow an
+// unsorted page, the cursor of the next one.
+func (f *finder) records() ([]Record, string) {
+	if f.top != nil {
+		return page(f.top.sorted(), f.opts.Skip, f.opts.Limit), ""
+	}
+
+	recs := page(f.recs, f.opts.Skip, f.opts.Limit)
+	if f.opts.Limit == 0 || len(f.recs) <= f.opts.Skip+f.opts.Limit || len(recs) == 0 {
+		return recs, ""
+	}
+	return recs, encodeCursor(recs[len(recs)-1])
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// findTestDocs are sorted and projected by the find tests.
+var findTestDocs = map[string]string{
+	"a": `{"name": "ann", "age": 30, "tags": [3, 1], "address": {"city": "Austin", "zip": "1"}}`,
+	"b": `{"name": "bob", "age": 25, "tags": [2], "address": {"city": "Boston", "zip": "2"}}`,
+	"c": `{"name": "cat", "age": 30, "address": {"city": "Austin", "zip": "3"}}`,
+	"d": `{"name": "dan", "age": "40", "tags": [5, 0], "items": [{"sku": "x", "qty": 1}, {"sku": "y", "qty": 2}]}`,
+}
+
+func writeFindTestDocs(t *testing.T, d *Driver, collection string) {
+	t.Helper()
+	for name, doc := range findTestDocs {
+		if err := d.Write(collection, name, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAllWith(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeFindTestDocs(t, d, "people")
+
+	tests := []struct {
+		name string
+		opts *FindOptions
+		want []string
+	}{
+		{"no options", nil, []string{
+			`{"_id": "a", "name": "ann", "age": 30, "tags": [3, 1], "address": {"city": "Austin", "zip": "1"}}`,
+			`{"_id": "b", "name": "bob", "age": 25, "tags": [2], "address": {"city": "Boston", "zip": "2"}}`,
+			`{"_id": "c", "name": "cat", "age": 30, "address": {"city": "Austin", "zip": "3"}}`,
+			`{"_id": "d", "name": "dan", "age": "40", "tags": [5, 0], "items": [{"sku": "x", "qty": 1}, {"sku": "y", "qty": 2}]}`,
+		}},
+		{"sort ascending", &FindOptions{Sort: []SortField{{Path: "age"}}, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "b", "name": "bob"}`, `{"_id": "a", "name": "ann"}`, `{"_id": "c", "name": "cat"}`, `{"_id": "d", "name": "dan"}`,
+		}},
+		{"sort descending with ties", &FindOptions{Sort: []SortField{{Path: "age", Desc: true}, {Path: "name", Desc: true}}, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "d", "name": "dan"}`, `{"_id": "c", "name": "cat"}`, `{"_id": "a", "name": "ann"}`, `{"_id": "b", "name": "bob"}`,
+		}},
+		{"sort by nested field and missing field", &FindOptions{Sort: []SortField{{Path: "address.city"}}, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "d", "name": "dan"}`, `{"_id": "a", "name": "ann"}`, `{"_id": "c", "name": "cat"}`, `{"_id": "b", "name": "bob"}`,
+		}},
+		{"arrays sort by their smallest element", &FindOptions{Sort: []SortField{{Path: "tags"}}, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "c", "name": "cat"}`, `{"_id": "d", "name": "dan"}`, `{"_id": "a", "name": "ann"}`, `{"_id": "b", "name": "bob"}`,
+		}},
+		{"arrays sort descending by their largest element", &FindOptions{Sort: []SortField{{Path: "tags", Desc: true}}, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "d", "name": "dan"}`, `{"_id": "a", "name": "ann"}`, `{"_id": "b", "name": "bob"}`, `{"_id": "c", "name": "cat"}`,
+		}},
+		{"include nested fields", &FindOptions{Projection: map[string]bool{"address.city": true, "items.sku": true}}, []string{
+			`{"_id": "a", "address": {"city": "Austin"}}`,
+			`{"_id": "b", "address": {"city": "Boston"}}`,
+			`{"_id": "c", "address": {"city": "Austin"}}`,
+			`{"_id": "d", "items": [{"sku": "x"}, {"sku": "y"}]}`,
+		}},
+		{"exclude fields", &FindOptions{Projection: map[string]bool{"tags": false, "address": false, "items.qty": false}}, []string{
+			`{"_id": "a", "name": "ann", "age": 30}`,
+			`{"_id": "b", "name": "bob", "age": 25}`,
+			`{"_id": "c", "name": "cat", "age": 30}`,
+			`{"_id": "d", "name": "dan", "age": "40", "items": [{"sku": "x"}, {"sku": "y"}]}`,
+		}},
+		{"skip and limit", &FindOptions{Skip: 1, Limit: 2, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "b", "name": "bob"}`, `{"_id": "c", "name": "cat"}`,
+		}},
+		{"sorted page", &FindOptions{Sort: []SortField{{Path: "name", Desc: true}}, Skip: 1, Limit: 2, Projection: map[string]bool{"name": true}}, []string{
+			`{"_id": "c", "name": "cat"}`, `{"_id": "b", "name": "bob"}`,
+		}},
+		{"skip past the end", &FindOptions{Skip: 10}, nil},
+	}
+	for _, tt := range tests {
+		records, _, err := d.ReadAllWith("people", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(records) != len(tt.want) {
+			t.Errorf("%s: got %d records, want %d: %s", tt.name, len(records), len(tt.want), records)
+			continue
+		}
+		for i, b := range records {
+			var got, want interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want[i]), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: record %d = %s, want %s", tt.name, i, b, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSearchFindOptions(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeFindTestDocs(t, d, "people")
+	writeFindTestDocs(t, d, "staff")
+
+	res, err := d.Search(map[string]interface{}{"age": 30.0}, &SearchOptions{
+		Documents:   true,
+		FindOptions: FindOptions{Sort: []SortField{{Path: "name", Desc: true}}, Projection: map[string]bool{"name": true}, Limit: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		{Collection: "people", Resource: "c", Document: map[string]interface{}{"_id": "c", "name": "cat"}},
+		{Collection: "staff", Resource: "c", Document: map[string]interface{}{"_id": "c", "name": "cat"}},
+		{Collection: "people", Resource: "a", Document: map[string]interface{}{"_id": "a", "name": "ann"}},
+	}
+	if !reflect.DeepEqual(res.Records, want) {
+		t.Errorf("records = %+v, want %+v", res.Records, want)
+	}
+}
+
+func TestInvalidFindOptions(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeFindTestDocs(t, d, "people")
+
+	for _, opts := range []FindOptions{
+		{Skip: -1},
+		{Limit: -1},
+		{Sort: []SortField{{Path: ""}}},
+		{Projection: map[string]bool{"": true}},
+		{Projection: map[string]bool{"name": true, "age": false}},
+		{Cursor: "!"},
+		{Cursor: "e30"},
+		{Cursor: encodeCursor(Record{Collection: "people", Resource: "a"}), Sort: []SortField{{Path: "age"}}},
+	} {
+		if _, _, err := d.ReadAllWith("people", &opts); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("ReadAllWith(%+v): err = %v, want ErrInvalidQuery", opts, err)
+		}
+		if _, err := d.Search(nil, &SearchOptions{FindOptions: opts}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Search(%+v): err = %v, want ErrInvalidQuery", opts, err)
+		}
+	}
+}
+
+// searchPages pages through the documents of collection with Search, limit
+// at a time, and returns their resource names.
+func searchPages(t *testing.T, d *Driver, collection string, limit int) []string {
+	t.Helper()
+	var names []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("paging does not end")
+		}
+		res, err := d.Search(nil, &SearchOptions{
+			Collections: []string{collection},
+			FindOptions: FindOptions{Limit: limit, Cursor: cursor},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range res.Records {
+			names = append(names, rec.Resource)
+		}
+		if res.NextCursor == "" {
+			return names
+		}
+		cursor = res.NextCursor
+	}
+}
+
+// readAllPages is searchPages with ReadAllWith. The documents must hold
+// their resource name in a "name" field.
+func readAllPages(t *testing.T, d *Driver, collection string, limit int) []string {
+	t.Helper()
+	var names []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("paging does not end")
+		}
+		records, next, err := d.ReadAllWith(collection, &FindOptions{Limit: limit, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, recordNames(t, records)...)
+		if next == "" {
+			return names
+		}
+		cursor = next
+	}
+}
+
+// recordNames returns the "name" field of each record.
+func recordNames(t *testing.T, records []json.RawMessage) []string {
+	t.Helper()
+	var names []string
+	for _, b := range records {
+		var doc struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, doc.Name)
+	}
+	return names
+}
+
+func TestCursorPages(t *testing.T) {
+	d := newTestDriver(t, nil)
+	var want []string
+	for i := 0; i < 7; i++ {
+		name := fmt.Sprintf("r%d", i)
+		want = append(want, name)
+		for _, collection := range []string{"a", "b"} {
+			if err := d.Write(collection, name, map[string]interface{}{"name": name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, limit := range []int{1, 2, 3, 7, 10} {
+		if got := searchPages(t, d, "a", limit); !reflect.DeepEqual(got, want) {
+			t.Errorf("Search pages of %d = %v, want %v", limit, got, want)
+		}
+		if got := readAllPages(t, d, "a", limit); !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadAllWith pages of %d = %v, want %v", limit, got, want)
+		}
+	}
+
+	// A search over several collections pages through them in order.
+	var got []string
+	cursor := ""
+	for page := 0; page < 100; page++ {
+		res, err := d.Search(nil, &SearchOptions{
+			Collections: []string{"b", "a", "b"},
+			FindOptions: FindOptions{Limit: 4, Cursor: cursor},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range res.Records {
+			got = append(got, rec.Collection+"/"+rec.Resource)
+		}
+		if cursor = res.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(got) != 14 || got[0] != "a/r0" || got[6] != "a/r6" || got[7] != "b/r0" || got[13] != "b/r6" {
+		t.Errorf("pages over two collections = %v", got)
+	}
+}
+
+func TestCursorNamesSharingAPrefix(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, name := range []string{"b", "a-1", "a", "a.b", "ab"} {
+		if err := d.Write("c", name, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"a", "a-1", "a.b", "ab", "b"}
+	if got := searchPages(t, d, "c", 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("Search pages = %v, want %v", got, want)
+	}
+	if got := readAllPages(t, d, "c", 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAllWith pages = %v, want %v", got, want)
+	}
+}
+
+func TestCursorAcrossWritesAndDeletes(t *testing.T) {
+	// A pager returns a page of the records of collection c and the cursor
+	// to the next one.
+	pagers := map[string]func(t *testing.T, d *Driver, cursor string) ([]string, string){
+		"Search": func(t *testing.T, d *Driver, cursor string) ([]string, string) {
+			res, err := d.Search(nil, &SearchOptions{
+				Collections: []string{"c"},
+				FindOptions: FindOptions{Limit: 2, Cursor: cursor},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res.Resources()["c"], res.NextCursor
+		},
+		"ReadAllWith": func(t *testing.T, d *Driver, cursor string) ([]string, string) {
+			records, next, err := d.ReadAllWith("c", &FindOptions{Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return recordNames(t, records), next
+		},
+	}
+
+	for name, page := range pagers {
+		t.Run(name, func(t *testing.T) {
+			d := newTestDriver(t, nil)
+			for _, resource := range []string{"a", "c", "e", "g", "i"} {
+				if err := d.Write("c", resource, map[string]interface{}{"name": resource}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			names, cursor := page(t, d, "")
+			got = append(got, names...)
+			if cursor == "" {
+				t.Fatal("first page has no cursor")
+			}
+
+			// Records before the cursor are neither repeated nor
+			// revisited, those after it are found, and deleted ones are
+			// skipped, including the one the cursor points after.
+			for _, resource := range []string{"b", "f"} {
+				if err := d.Write("c", resource, map[string]interface{}{"name": resource}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, resource := range []string{"c", "e"} {
+				if err := d.Delete("c", resource); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := 0; cursor != ""; i++ {
+				if i > 10 {
+					t.Fatal("paging does not end")
+				}
+				names, cursor = page(t, d, cursor)
+				got = append(got, names...)
+			}
+
+			want := []string{"a", "c", "f", "g", "i"}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("pages = %v, want %v", got, want)
+			}
+		})
+	}
+}
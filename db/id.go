@@ -0,0 +1,127 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// idField holds the resource name of a document inside the document.
+// Writes store it; documents written before they did get it on read.
+const idField = "_id"
+
+// ErrInvalidID is returned for a document whose "_id" is not its resource
+// name.
+var ErrInvalidID = errors.New("invalid _id")
+
+// idProcess and idCounter make the IDs generated by NewID unique: the
+// first is random per process, the second counts up from a random start.
+var (
+	idProcess [5]byte
+	idCounter uint32
+)
+
+func init() {
+	var seed [4]byte
+	if _, err := rand.Read(idProcess[:]); err != nil {
+		panic(err)
+	}
+	if _, err := rand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+	idCounter = binary.BigEndian.Uint32(seed[:])
+}
+
+// NewID returns a new unique resource name laid out like a MongoDB
+// ObjectId: 24 hex digits encoding a 4-byte timestamp in seconds, 5 random
+// bytes and a 3-byte counter. IDs generated later sort after earlier ones,
+// except for IDs made within the same second by different processes.
+func NewID() string {
+	var id [12]byte
+	binary.BigEndian.PutUint32(id[0:4], uint32(time.Now().Unix()))
+	copy(id[4:9], idProcess[:])
+	n := atomic.AddUint32(&idCounter, 1)
+	id[9], id[10], id[11] = byte(n>>16), byte(n>>8), byte(n)
+	return hex.EncodeToString(id[:])
+}
+
+// Insert stores v as a new document of collection and returns its resource
+// name. The name is the string in the "_id" field of v, or a new NewID
+// stored in "_id" if v has none, so v must encode to a JSON object.
+// Inserting an "_id" that is already present fails with ErrAlreadyExists.
+func (d *Driver) Insert(collection string, v interface{}) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("Missing collection - no place to save record!")
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	spans, ok := objectFields(b)
+	if !ok {
+		return "", fmt.Errorf("Only a JSON object can be inserted - unable to save record in %s!", collection)
+	}
+
+	var id string
+	if span, ok := spans[idField]; ok {
+		if json.Unmarshal(b[span[0]:span[1]], &id) != nil || id == "" {
+			return "", fmt.Errorf("%w: %s must be a non-empty string", ErrInvalidID, b[span[0]:span[1]])
+		}
+	} else {
+		id = NewID()
+		value, _ := json.Marshal(id)
+		if b, _, err = setFields(b, []rawField{{idField, value}}); err != nil {
+			return "", err
+		}
+	}
+
+	if err := d.WriteWith(collection, id, json.RawMessage(b), &WriteOptions{Mode: InsertOnly}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// withID sets the "_id" of a decoded document to resource if it has none.
+func withID(doc map[string]interface{}, resource string) map[string]interface{} {
+	if doc != nil {
+		if _, ok := doc[idField]; !ok {
+			doc[idField] = resource
+		}
+	}
+	return doc
+}
+
+// rawWithID is withID for a stored record. Records that are not JSON
+// objects are returned unchanged.
+func rawWithID(b []byte, resource string) []byte {
+	if bytes.Contains(b, []byte(`"`+idField+`"`)) {
+		// Most likely present; decode to make sure it is not only nested.
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(b, &fields) != nil || fields == nil {
+			return b
+		}
+		if _, ok := fields[idField]; ok {
+			return b
+		}
+	}
+
+	trimmed := bytes.TrimLeft(b, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return b
+	}
+	id, _ := json.Marshal(resource)
+	out := make([]byte, 0, len(trimmed)+len(id)+16)
+	out = append(out, `{"`+idField+`":`...)
+	out = append(out, id...)
+	if rest := bytes.TrimLeft(trimmed[1:], " \t\r\n"); len(rest) > 0 && rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, trimmed[1:]...)
+}
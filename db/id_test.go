@@ -0,0 +1,82 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	hex24 := regexp.MustCompile(`^[0-9a-f]{24}$`)
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		id := NewID()
+		if !hex24.MatchString(id) {
+			t.Fatalf("NewID() = %q, want 24 hex digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewID() repeated %q", id)
+		}
+		// The counter may wrap around once, from its random start.
+		if id <= prev && id[:18] != prev[:18] {
+			t.Errorf("NewID() = %q after %q", id, prev)
+		}
+		seen[id] = true
+		prev = id
+	}
+}
+
+func TestInsert(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	first, err := d.Insert("c", json.RawMessage(`{"big": 9007199254740993}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.Insert("c", map[string]interface{}{"n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 24 || len(second) != 24 || first == second {
+		t.Errorf("generated IDs %q and %q", first, second)
+	}
+
+	b, err := d.readStored("c", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "9007199254740993") || !strings.Contains(string(b), `"_id": "`+first+`"`) {
+		t.Errorf("stored record:\n%s", b)
+	}
+
+	tests := []struct {
+		name string
+		v    interface{}
+		id   string
+		err  error
+	}{
+		{"own _id", map[string]interface{}{"_id": "mine"}, "mine", nil},
+		{"same _id again", map[string]interface{}{"_id": "mine"}, "", ErrAlreadyExists},
+		{"empty _id", json.RawMessage(`{"_id": ""}`), "", ErrInvalidID},
+		{"number _id", json.RawMessage(`{"_id": 1}`), "", ErrInvalidID},
+		{"array", []int{1}, "", nil},
+	}
+	for _, tt := range tests {
+		id, err := d.Insert("c", tt.v)
+		switch {
+		case tt.id != "":
+			if err != nil || id != tt.id {
+				t.Errorf("%s: Insert = %q, %v, want %q", tt.name, id, err, tt.id)
+			}
+		case tt.err != nil:
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+			}
+		case err == nil:
+			t.Errorf("%s: Insert = %q, want an error", tt.name, id)
+		}
+	}
+}
@@ -0,0 +1,824 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// IndexSpec describes a secondary index on one or more document fields.
+type IndexSpec struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+	Unique bool     `json:"unique,omitempty"`
+	Sparse bool     `json:"sparse,omitempty"`
+	Text   bool     `json:"text,omitempty"`
+}
+
+// IndexOptions tunes CreateIndex.
+type IndexOptions struct {
+	// Name overrides the default index name, the field paths joined by "_".
+	Name string
+	// Unique rejects writes that would give two resources the same key.
+	Unique bool
+	// Sparse leaves out documents that have none of the indexed fields,
+	// so a sparse unique index only constrains documents that set them.
+	Sparse bool
+	// Text creates a text index on string fields instead, for $text
+	// queries and TextSearch. A collection has at most one text index,
+	// which can cover several fields.
+	Text bool
+}
+
+// DuplicateKeyError is returned when a write would give two resources the
+// same key in a unique index.
+type DuplicateKeyError struct {
+	Collection string
+	Index      string
+	Key        []interface{}
+	// Resource is the resource that already holds the key.
+	Resource string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	key, _ := json.Marshal(e.Key)
+	return fmt.Sprintf("duplicate key %s for unique index %s on %s: already used by resource %q",
+		key, e.Index, e.Collection, e.Resource)
+}
+
+type indexEntry struct {
+	key      []interface{}
+	resource string
+}
+
+// index keeps the entries of a secondary index sorted by key so that
+// equality, $in and range predicates can be answered with a binary search.
+type index struct {
+	spec     IndexSpec
+	entries  []indexEntry               // sorted by key, then resource
+	keys     map[string][][]interface{} // keys currently indexed per resource
+	multikey bool                       // some document indexed an array element by element
+	dirty    bool                       // changed since the last snapshot
+}
+
+func newIndex(spec IndexSpec) *index {
+	return &index{spec: spec, keys: make(map[string][][]interface{})}
+}
+
+func compareKeys(a, b []interface{}) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := orderValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+func compareEntries(a, b indexEntry) int {
+	if c := compareKeys(a.key, b.key); c != 0 {
+		return c
+	}
+	return strings.Compare(a.resource, b.resource)
+}
+
+// indexKeys returns the keys under which doc is indexed. Fields are
+// resolved like query paths, a missing field is indexed as null and an
+// array is indexed element by element, so that a query for a single
+// element can use the index.
+func (idx *index) indexKeys(doc map[string]interface{}) [][]interface{} {
+	if idx.spec.Sparse {
+		present := false
+		for _, field := range idx.spec.Fields {
+			if len(fieldValues(doc, field)) > 0 {
+				present = true
+				break
+			}
+		}
+		if !present {
+			return nil
+		}
+	}
+
+	keys := [][]interface{}{{}}
+	for _, field := range idx.spec.Fields {
+		var values []interface{}
+		for _, v := range fieldValues(doc, field) {
+			if arr, ok := v.([]interface{}); ok && len(arr) > 0 {
+				values = append(values, arr...)
+			} else {
+				values = append(values, v)
+			}
+		}
+		switch {
+		case len(values) == 0:
+			values = []interface{}{nil}
+		case len(values) > 1:
+			idx.multikey = true
+		}
+
+		next := make([][]interface{}, 0, len(keys)*len(values))
+		for _, key := range keys {
+			for _, value := range values {
+				next = append(next, append(key[:len(key):len(key)], value))
+			}
+		}
+		keys = next
+	}
+	return keys
+}
+
+// insert (re)indexes resource under the keys derived from doc.
+func (idx *index) insert(resource string, doc map[string]interface{}) {
+	idx.remove(resource)
+
+	keys := idx.indexKeys(doc)
+	for _, key := range keys {
+		e := indexEntry{key: key, resource: resource}
+		i := sort.Search(len(idx.entries), func(i int) bool {
+			return compareEntries(idx.entries[i], e) >= 0
+		})
+		if i < len(idx.entries) && compareEntries(idx.entries[i], e) == 0 {
+			continue
+		}
+		idx.entries = append(idx.entries, indexEntry{})
+		copy(idx.entries[i+1:], idx.entries[i:])
+		idx.entries[i] = e
+	}
+	idx.keys[resource] = keys
+	idx.dirty = true
+}
+
+// holders returns the resources indexed under key.
+func (idx *index) holders(key []interface{}) []string {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return compareKeys(idx.entries[i].key, key) >= 0
+	})
+
+	var resources []string
+	for ; i < len(idx.entries) && compareKeys(idx.entries[i].key, key) == 0; i++ {
+		resources = append(resources, idx.entries[i].resource)
+	}
+	return resources
+}
+
+// remove drops every entry of resource.
+func (idx *index) remove(resource string) {
+	keys, ok := idx.keys[resource]
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		e := indexEntry{key: key, resource: resource}
+		i := sort.Search(len(idx.entries), func(i int) bool {
+			return compareEntries(idx.entries[i], e) >= 0
+		})
+		if i < len(idx.entries) && compareEntries(idx.entries[i], e) == 0 {
+			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+		}
+	}
+	delete(idx.keys, resource)
+	idx.dirty = true
+}
+
+func (idx *index) clear() {
+	idx.entries = nil
+	idx.keys = make(map[string][][]interface{})
+	idx.multikey = false
+	idx.dirty = true
+}
+
+// predicate is the part of a query on a single field that an index can
+// answer: a set of equality values or a range within one type.
+type predicate struct {
+	values         []interface{}
+	lo, hi         interface{}
+	hasLo, hasHi   bool
+	loIncl, hiIncl bool
+	rank           int
+}
+
+func isScalar(v interface{}) bool {
+	switch typeRank(v) {
+	case 1, 2, 3, 6:
+		return true
+	}
+	return false
+}
+
+// fieldPredicate extracts what an index can use from the condition on a
+// field. Everything else in the condition is left to matchesQuery, which
+// re-checks every document the index returns.
+func fieldPredicate(cond interface{}) (*predicate, bool) {
+	if isScalar(cond) {
+		return &predicate{values: []interface{}{cond}}, true
+	}
+
+	ops, ok := cond.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	for op := range ops {
+		if !strings.HasPrefix(op, "$") {
+			// A literal sub-document, not an operator expression.
+			return nil, false
+		}
+	}
+
+	if v, ok := ops["$eq"]; ok && isScalar(v) {
+		return &predicate{values: []interface{}{v}}, true
+	}
+	if in, ok := ops["$in"].([]interface{}); ok {
+		for _, v := range in {
+			if !isScalar(v) {
+				return nil, false
+			}
+		}
+		return &predicate{values: in}, true
+	}
+
+	p := &predicate{}
+	for op, v := range ops {
+		if op != "$gt" && op != "$gte" && op != "$lt" && op != "$lte" {
+			continue
+		}
+		rank := typeRank(v)
+		if rank != 2 && rank != 3 {
+			continue
+		}
+		if (p.hasLo || p.hasHi) && rank != p.rank {
+			return nil, false
+		}
+		p.rank = rank
+		if op == "$gt" || op == "$gte" {
+			p.lo, p.hasLo, p.loIncl = v, true, op == "$gte"
+		} else {
+			p.hi, p.hasHi, p.hiIncl = v, true, op == "$lte"
+		}
+	}
+	if !p.hasLo && !p.hasHi {
+		return nil, false
+	}
+	return p, true
+}
+
+// matchesNull reports whether p can be satisfied by a missing field.
+func matchesNull(p *predicate) bool {
+	for _, v := range p.values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *predicate) isRange() bool {
+	return p.values == nil
+}
+
+// matches reports whether a single key component satisfies p.
+func (p *predicate) matches(v interface{}) bool {
+	if !p.isRange() {
+		for _, want := range p.values {
+			if orderValues(v, want) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if typeRank(v) != p.rank {
+		return false
+	}
+	if p.hasLo {
+		if c := orderValues(v, p.lo); c < 0 || (c == 0 && !p.loIncl) {
+			return false
+		}
+	}
+	if p.hasHi {
+		if c := orderValues(v, p.hi); c > 0 || (c == 0 && !p.hiIncl) {
+			return false
+		}
+	}
+	return true
+}
+
+// plan picks the index that narrows query the most. It returns nil when
+// the collection has to be scanned in full. The caller must hold cs.mu.
+func (cs *collState) plan(query map[string]interface{}) (*index, []*predicate) {
+	var (
+		best      *index
+		bestPreds []*predicate
+		bestScore int
+	)
+
+	names := make([]string, 0, len(cs.indexes))
+	for name := range cs.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		idx := cs.indexes[name]
+		preds := make([]*predicate, len(idx.spec.Fields))
+		score := 0
+		for i, field := range idx.spec.Fields {
+			cond, ok := query[field]
+			if !ok {
+				continue
+			}
+			if p, ok := fieldPredicate(cond); ok {
+				preds[i] = p
+				score++
+			}
+		}
+		if preds[0] == nil {
+			continue
+		}
+		if idx.spec.Sparse && matchesNull(preds[0]) {
+			// Documents missing the field are not in a sparse index.
+			continue
+		}
+
+		// An index on the leading field is what makes the scan cheap:
+		// equality beats $in beats a range.
+		switch {
+		case !preds[0].isRange() && len(preds[0].values) == 1:
+			score += 30
+		case !preds[0].isRange():
+			score += 20
+		default:
+			score += 10
+		}
+		if score > bestScore {
+			best, bestPreds, bestScore = idx, preds, score
+		}
+	}
+	return best, bestPreds
+}
+
+// lookup returns the sorted resources whose entries satisfy preds, where
+// preds[0] is the predicate on the leading field.
+func (idx *index) lookup(preds []*predicate) []string {
+	first := preds[0]
+	if first.isRange() && idx.multikey && first.hasLo && first.hasHi {
+		// Different elements of an array may satisfy each bound, so the
+		// bounds cannot be intersected on a multikey index.
+		relaxed := *first
+		relaxed.hasHi = false
+		first = &relaxed
+	}
+
+	seen := make(map[string]bool)
+	collect := func(start int) {
+		for i := start; i < len(idx.entries); i++ {
+			e := idx.entries[i]
+			if !first.matches(e.key[0]) {
+				if orderValues(e.key[0], first.upper()) > 0 {
+					return
+				}
+				continue
+			}
+			ok := true
+			for j := 1; j < len(preds) && ok; j++ {
+				if preds[j] != nil && !preds[j].matches(e.key[j]) {
+					ok = false
+				}
+			}
+			if ok {
+				seen[e.resource] = true
+			}
+		}
+	}
+
+	if !first.isRange() {
+		for _, v := range first.values {
+			start := sort.Search(len(idx.entries), func(i int) bool {
+				return orderValues(idx.entries[i].key[0], v) >= 0
+			})
+			for i := start; i < len(idx.entries) && orderValues(idx.entries[i].key[0], v) == 0; i++ {
+				e := idx.entries[i]
+				ok := true
+				for j := 1; j < len(preds) && ok; j++ {
+					if preds[j] != nil && !preds[j].matches(e.key[j]) {
+						ok = false
+					}
+				}
+				if ok {
+					seen[e.resource] = true
+				}
+			}
+		}
+	} else {
+		collect(sort.Search(len(idx.entries), func(i int) bool {
+			k := idx.entries[i].key[0]
+			if first.hasLo {
+				return orderValues(k, first.lo) >= 0
+			}
+			return typeRank(k) >= first.rank
+		}))
+	}
+
+	resources := make([]string, 0, len(seen))
+	for resource := range seen {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// upper returns a value that sorts after every key a range predicate can
+// match, which lets a scan stop early.
+func (p *predicate) upper() interface{} {
+	if p.hasHi {
+		return p.hi
+	}
+	// Every value of the next type bracket sorts after the range.
+	switch p.rank {
+	case 2:
+		return ""
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// indexFormat is the version of the index snapshot format. Snapshots of
+// another version are rebuilt on load; version 2 resolves fields through
+// arrays of sub-documents, version 3 orders dates after booleans.
+const indexFormat = 3
+
+// indexSnapshot is the on-disk form of an index.
+type indexSnapshot struct {
+	Version int      `json:"version"`
+	Fields  []string `json:"fields"`
+	Entries []struct {
+		Key      []interface{} `json:"k"`
+		Resource string        `json:"r"`
+	} `json:"entries"`
+}
+
+func (d *Driver) indexPath(collection, name string) string {
+	return filepath.Join(d.dir, metaDir, collection, name+".index")
+}
+
+// saveIndex writes a snapshot of idx. Changes made after the snapshot are
+// recovered by replaying the write-ahead log.
+func (d *Driver) saveIndex(collection string, idx *index) error {
+	snap := indexSnapshot{Version: indexFormat, Fields: idx.spec.Fields}
+	snap.Entries = make([]struct {
+		Key      []interface{} `json:"k"`
+		Resource string        `json:"r"`
+	}, len(idx.entries))
+	for i, e := range idx.entries {
+		snap.Entries[i].Key = e.key
+		snap.Entries[i].Resource = e.resource
+	}
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := d.writeFileAtomic(d.indexPath(collection, idx.spec.Name), b); err != nil {
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// loadIndex restores an index from its snapshot, or rebuilds it from the
+// collection if the snapshot is missing or does not match spec.
+func (d *Driver) loadIndex(collection string, spec IndexSpec) (*index, error) {
+	idx := newIndex(spec)
+
+	var snap indexSnapshot
+	b, err := ioutil.ReadFile(d.indexPath(collection, spec.Name))
+	if err == nil && json.Unmarshal(b, &snap) == nil &&
+		snap.Version == indexFormat && equalFields(snap.Fields, spec.Fields) {
+		for _, e := range snap.Entries {
+			idx.entries = append(idx.entries, indexEntry{key: e.Key, resource: e.Resource})
+			idx.keys[e.Resource] = append(idx.keys[e.Resource], e.Key)
+			if len(idx.keys[e.Resource]) > 1 {
+				idx.multikey = true
+			}
+		}
+		return idx, nil
+	}
+
+	d.log.Info("Rebuilding index %s on %s", spec.Name, collection)
+	if err := d.buildIndex(collection, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// buildIndex indexes every document currently stored in collection.
+// Expired documents are indexed too, until they are deleted, but do not
+// conflict with others in a unique index.
+func (d *Driver) buildIndex(collection string, idx *index) error {
+	resources, err := d.resources(collection)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	exp := d.expiry(collection)
+	expired := make(map[string]bool)
+	for _, resource := range resources {
+		b, err := d.readStored(collection, resource)
+		if err != nil {
+			return err
+		}
+		doc := withID(decodeObject(b), resource)
+		expired[resource] = exp.expired(doc)
+		if idx.spec.Unique && !expired[resource] {
+			for _, key := range idx.indexKeys(doc) {
+				for _, holder := range idx.holders(key) {
+					if holder != resource && !expired[holder] {
+						return &DuplicateKeyError{Collection: collection, Index: idx.spec.Name, Key: key, Resource: holder}
+					}
+				}
+			}
+		}
+		idx.insert(resource, doc)
+	}
+	return nil
+}
+
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeObject decodes a stored record. Records that are not JSON objects
+// are treated as empty documents.
+func decodeObject(b []byte) map[string]interface{} {
+	var doc map[string]interface{}
+	if json.Unmarshal(b, &doc) != nil || doc == nil {
+		return map[string]interface{}{}
+	}
+	return doc
+}
+
+// updateIndexes keeps the indexes of op's collection in step with an
+// operation that has just been applied.
+func (d *Driver) updateIndexes(op walOp) {
+	cs := d.state(op.Collection)
+	if cs == nil {
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if len(cs.indexes) == 0 && cs.text == nil && cs.capped == nil {
+		return
+	}
+
+	var doc map[string]interface{}
+	if op.Kind == opWrite {
+		doc = decodeObject(op.Data)
+	}
+	for _, idx := range cs.indexes {
+		switch op.Kind {
+		case opWrite:
+			idx.insert(op.Resource, doc)
+		case opDelete:
+			idx.remove(op.Resource)
+		case opDeleteAll:
+			idx.clear()
+		}
+	}
+	if t := cs.text; t != nil {
+		switch op.Kind {
+		case opWrite:
+			t.insert(op.Resource, doc)
+		case opDelete:
+			t.remove(op.Resource)
+		case opDeleteAll:
+			t.clear()
+		}
+	}
+	if c := cs.capped; c != nil {
+		switch op.Kind {
+		case opWrite:
+			c.put(op.Resource, int64(len(op.Data)))
+		case opDelete:
+			c.remove(op.Resource)
+		case opDeleteAll:
+			c.clear()
+		}
+	}
+}
+
+// saveIndexes snapshots every index, and the insertion order of every
+// capped collection, changed since the last checkpoint.
+func (d *Driver) saveIndexes() error {
+	d.catalogMu.Lock()
+	states := make(map[string]*collState, len(d.colls))
+	for name, cs := range d.colls {
+		states[name] = cs
+	}
+	d.catalogMu.Unlock()
+
+	for collection, cs := range states {
+		cs.mu.Lock()
+		for _, idx := range cs.indexes {
+			if !idx.dirty {
+				continue
+			}
+			if err := d.saveIndex(collection, idx); err != nil {
+				cs.mu.Unlock()
+				return err
+			}
+		}
+		if cs.text != nil && cs.text.dirty {
+			if err := d.saveTextIndex(collection, cs.text); err != nil {
+				cs.mu.Unlock()
+				return err
+			}
+		}
+		if cs.capped != nil && cs.capped.dirty {
+			if err := d.saveCappedOrder(collection, cs.capped); err != nil {
+				cs.mu.Unlock()
+				return err
+			}
+		}
+		cs.mu.Unlock()
+	}
+	return nil
+}
+
+// CreateIndex builds a persistent index on fieldPath in collection. A
+// compound index lists several field paths separated by commas, for
+// example "lastName,firstName". Search uses indexes for equality, $in and
+// range conditions on their leading field. Creating an index that already
+// exists with the same fields and options is a no-op. A unique index
+// cannot be created while the collection holds duplicates. With
+// opts.Text, the index is a text index over the listed fields.
+func (d *Driver) CreateIndex(collection, fieldPath string, opts *IndexOptions) (IndexSpec, error) {
+	if opts == nil {
+		opts = &IndexOptions{}
+	}
+
+	if collection == "" {
+		return IndexSpec{}, fmt.Errorf("Missing collection - unable to create index!")
+	}
+	if opts.Text && (opts.Unique || opts.Sparse) {
+		return IndexSpec{}, fmt.Errorf("A text index cannot be unique or sparse")
+	}
+
+	var fields []string
+	for _, field := range strings.Split(fieldPath, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return IndexSpec{}, fmt.Errorf("Missing field - unable to create index on %q!", fieldPath)
+		}
+		fields = append(fields, field)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = strings.Join(fields, "_")
+		if opts.Text {
+			name += "_text"
+		}
+	}
+	if strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return IndexSpec{}, fmt.Errorf("Invalid index name %q", name)
+	}
+	spec := IndexSpec{Name: name, Fields: fields, Unique: opts.Unique, Sparse: opts.Sparse, Text: opts.Text}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	d.ckpt.RLock()
+	defer d.ckpt.RUnlock()
+
+	cs := d.getOrCreateState(collection)
+	cs.mu.RLock()
+	existing, ok := cs.spec(name)
+	text := cs.text
+	cs.mu.RUnlock()
+	if ok {
+		if equalFields(existing.Fields, fields) && existing.Unique == spec.Unique && existing.Sparse == spec.Sparse && existing.Text == spec.Text {
+			return existing, nil
+		}
+		return IndexSpec{}, fmt.Errorf("%w: index %s on %s has different options", ErrAlreadyExists, name, collection)
+	}
+	if spec.Text && text != nil {
+		return IndexSpec{}, fmt.Errorf("%w: %s already has text index %s", ErrAlreadyExists, collection, text.spec.Name)
+	}
+
+	var (
+		idx *index
+		t   *textIndex
+	)
+	if spec.Text {
+		t = newTextIndex(spec)
+		if err := d.buildTextIndex(collection, t); err != nil {
+			return IndexSpec{}, err
+		}
+		if err := d.saveTextIndex(collection, t); err != nil {
+			return IndexSpec{}, err
+		}
+	} else {
+		idx = newIndex(spec)
+		if err := d.buildIndex(collection, idx); err != nil {
+			return IndexSpec{}, err
+		}
+		if err := d.saveIndex(collection, idx); err != nil {
+			return IndexSpec{}, err
+		}
+	}
+
+	meta := cs.meta
+	meta.Indexes = append(append([]IndexSpec(nil), meta.Indexes...), spec)
+	if err := d.saveMeta(collection, meta); err != nil {
+		return IndexSpec{}, err
+	}
+
+	cs.mu.Lock()
+	cs.meta = meta
+	if t != nil {
+		cs.text = t
+	} else {
+		cs.indexes[name] = idx
+	}
+	cs.mu.Unlock()
+	return spec, nil
+}
+
+// spec returns the definition of the named index. The caller must hold
+// cs.mu or the collection mutex.
+func (cs *collState) spec(name string) (IndexSpec, bool) {
+	for _, spec := range cs.meta.Indexes {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return IndexSpec{}, false
+}
+
+// ListIndexes returns the indexes defined on collection.
+func (d *Driver) ListIndexes(collection string) []IndexSpec {
+	cs := d.state(collection)
+	if cs == nil {
+		return []IndexSpec{}
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return append([]IndexSpec{}, cs.meta.Indexes...)
+}
+
+// DropIndex removes the named index from collection.
+func (d *Driver) DropIndex(collection, name string) error {
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cs := d.state(collection)
+	if cs == nil {
+		return fmt.Errorf("%w: index %s on %s", ErrNotFound, name, collection)
+	}
+	if _, ok := cs.spec(name); !ok {
+		return fmt.Errorf("%w: index %s on %s", ErrNotFound, name, collection)
+	}
+
+	meta := cs.meta
+	meta.Indexes = nil
+	for _, spec := range cs.meta.Indexes {
+		if spec.Name != name {
+			meta.Indexes = append(meta.Indexes, spec)
+		}
+	}
+	if err := d.saveMeta(collection, meta); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	cs.meta = meta
+	delete(cs.indexes, name)
+	if cs.text != nil && cs.text.spec.Name == name {
+		cs.text = nil
+	}
+	cs.mu.Unlock()
+
+	err := os.Remove(d.indexPath(collection, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
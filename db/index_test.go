@@ -0,0 +1,183 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// indexTestDocs covers the values an index has to order and match:
+// numbers and numeric strings, arrays, nested fields, null and missing
+// fields.
+var indexTestDocs = map[string]string{
+	"d01": `{"n": 1, "s": "x", "tags": ["a", "b"], "a": {"b": 1}}`,
+	"d02": `{"n": 2, "s": "y", "tags": ["b"], "a": {"b": 2}, "opt": 1}`,
+	"d03": `{"n": 2.5, "s": "x", "tags": [], "a": {"b": [1, 3]}}`,
+	"d04": `{"n": "2", "s": "z", "tags": "a", "opt": null}`,
+	"d05": `{"n": null, "s": "x", "a": {}}`,
+	"d06": `{"s": "y", "tags": [["a"]], "a": [{"b": 2}, {"b": 4}]}`,
+	"d07": `{"n": [1, 5], "s": "y", "opt": 2}`,
+	"d08": `{"n": -3, "tags": ["c", "a"], "a": {"b": "1"}}`,
+	"d09": `{"n": 10, "s": "yy", "opt": "2"}`,
+	"d10": `{"n": {"v": 1}, "s": "x"}`,
+}
+
+var indexTestQueries = []string{
+	`{"n": 2}`,
+	`{"n": "2"}`,
+	`{"n": null}`,
+	`{"n": {"$eq": 1}}`,
+	`{"n": {"$in": [1, 2, "2", null]}}`,
+	`{"n": {"$gt": 1}}`,
+	`{"n": {"$gte": 1, "$lt": 5}}`,
+	`{"n": {"$lt": 0}}`,
+	`{"n": {"$gt": 2, "$lt": 4}}`,
+	`{"n": {"$gte": "1"}}`,
+	`{"n": 2, "s": "y"}`,
+	`{"n": {"$gt": 0}, "s": "x"}`,
+	`{"n": 2, "$or": [{"s": "x"}, {"s": "z"}]}`,
+	`{"n": {"$gte": 1, "$not": {"$gt": 2}}}`,
+	`{"s": "y"}`,
+	`{"s": {"$in": ["x", "yy"]}}`,
+	`{"s": {"$gt": "x", "$lte": "y"}}`,
+	`{"tags": "a"}`,
+	`{"tags": {"$in": ["b", "c"]}}`,
+	`{"tags": null}`,
+	`{"tags": {"$all": ["a", "b"]}, "n": {"$ne": 2}}`,
+	`{"a.b": 1}`,
+	`{"a.b": {"$gte": 2}}`,
+	`{"a.b": null}`,
+	`{"opt": 2}`,
+	`{"opt": {"$gte": 1}}`,
+	`{"opt": null}`,
+	`{"opt": {"$exists": true}, "s": {"$nin": ["y"]}}`,
+}
+
+// planIndex returns the name of the index Search uses for query in
+// collection, or "" when it scans the whole collection.
+func planIndex(d *Driver, collection string, query map[string]interface{}) string {
+	cs := d.state(collection)
+	if cs == nil {
+		return ""
+	}
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if idx, _ := cs.plan(query); idx != nil {
+		return idx.spec.Name
+	}
+	return ""
+}
+
+// searchNames returns the sorted names of the resources of collection
+// that Search finds for query.
+func searchNames(t *testing.T, d *Driver, collection string, query map[string]interface{}) []string {
+	t.Helper()
+	res, err := d.Search(query, &SearchOptions{Collections: []string{collection}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := res.Resources()[collection]
+	sort.Strings(names)
+	return names
+}
+
+// indexedMatchesScan checks that every query finds the same records in
+// collection "indexed" as in its unindexed copy "plain", and that every
+// index of "indexed" serves some query.
+func indexedMatchesScan(t *testing.T, d *Driver) {
+	t.Helper()
+	used := make(map[string]bool)
+	for _, q := range indexTestQueries {
+		var query map[string]interface{}
+		if err := json.Unmarshal([]byte(q), &query); err != nil {
+			t.Fatal(err)
+		}
+		want := searchNames(t, d, "plain", query)
+		got := searchNames(t, d, "indexed", query)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: indexed %v, scanned %v", q, got, want)
+		}
+		used[planIndex(d, "indexed", query)] = true
+	}
+	for _, spec := range d.ListIndexes("indexed") {
+		if !used[spec.Name] {
+			t.Errorf("no query used index %s", spec.Name)
+		}
+	}
+}
+
+func TestIndexedSearchMatchesScan(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(resource, doc string) {
+		t.Helper()
+		for _, collection := range []string{"plain", "indexed"} {
+			if err := d.Write(collection, resource, json.RawMessage(doc)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	remove := func(resource string) {
+		t.Helper()
+		for _, collection := range []string{"plain", "indexed"} {
+			if err := d.Delete(collection, resource); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Half the documents are indexed when the index is built, the rest
+	// as they are written.
+	for resource, doc := range indexTestDocs {
+		if resource < "d05" {
+			write(resource, doc)
+		}
+	}
+	for _, index := range []struct {
+		fields string
+		opts   *IndexOptions
+	}{
+		{"n,s", nil},
+		{"s", nil},
+		{"tags", nil},
+		{"a.b", nil},
+		{"opt", &IndexOptions{Sparse: true}},
+	} {
+		if _, err := d.CreateIndex("indexed", index.fields, index.opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for resource, doc := range indexTestDocs {
+		if resource >= "d05" {
+			write(resource, doc)
+		}
+	}
+	indexedMatchesScan(t, d)
+
+	// Rewrites and deletes must update the index entries.
+	write("d01", `{"n": 7, "s": "w", "tags": "d"}`)
+	write("d02", `{"s": "y", "opt": 3}`)
+	remove("d03")
+	remove("d07")
+	indexedMatchesScan(t, d)
+
+	// So must the replay of writes the index snapshot does not hold.
+	logOnly(t, d,
+		writeOp(t, "plain", "d04", map[string]interface{}{"n": 2, "s": "y"}),
+		writeOp(t, "indexed", "d04", map[string]interface{}{"n": 2, "s": "y"}),
+		walOp{Kind: opDelete, Collection: "plain", Resource: "d08"},
+		walOp{Kind: opDelete, Collection: "indexed", Resource: "d08"})
+	crash(t, d)
+
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	indexedMatchesScan(t, d)
+}
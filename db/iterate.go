@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// iterateBatch is the number of directory entries Iterate reads at a time.
+const iterateBatch = 256
+
+// Iterate calls fn for every record of collection, in directory order,
+// while reading the directory a batch at a time. Unlike ReadAll it never
+// holds more than one record in memory, so the first record arrives as
+// quickly for a huge collection as for a small one. A capped collection is
+// visited in insertion order instead. Iteration stops at the first error
+// returned by fn, which Iterate then returns. Records written or deleted
+// during the iteration may or may not be visited; expired records are not.
+func (d *Driver) Iterate(collection string, fn func(resource string, record json.RawMessage) error) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to read")
+	}
+	dir := filepath.Join(d.dir, collection)
+
+	f, err := os.Open(dir)
+	if err != nil {
+		d.log.Error("Directory check error: %s", err)
+		return err
+	}
+	defer f.Close()
+
+	exp := d.expiry(collection)
+	visit := func(name string) error {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			// Deleted since the directory was read.
+			return nil
+		}
+		if err != nil {
+			d.log.Error("Read file error: %s", err)
+			return err
+		}
+		if exp.expiredRaw(b) {
+			return nil
+		}
+		resource := strings.TrimSuffix(name, ".json")
+		return fn(resource, json.RawMessage(rawWithID(b, resource)))
+	}
+
+	if resources, ok := d.insertionOrder(collection); ok {
+		for _, resource := range resources {
+			if err := visit(resource + ".json"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		names, err := f.Readdirnames(iterateBatch)
+		for _, name := range names {
+			if filepath.Ext(name) != ".json" {
+				continue
+			}
+			if err := visit(name); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RegexSearchEach calls fn for every record of collection that matches
+// query, as RegexSearch would return them, while iterating the collection
+// with Iterate. Records that cannot be decoded are skipped.
+func (d *Driver) RegexSearchEach(collection string, query map[string]string, fn func(record map[string]interface{}) error) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to search")
+	}
+
+	match, err := compileRegexQuery(query)
+	if err != nil {
+		return err
+	}
+
+	return d.Iterate(collection, func(resource string, content json.RawMessage) error {
+		var record map[string]interface{}
+		if err := json.Unmarshal(content, &record); err != nil {
+			d.log.Error("Unmarshal error: %s", err)
+			return nil
+		}
+		if !match(record) {
+			return nil
+		}
+		return fn(record)
+	})
+}
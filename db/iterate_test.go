@@ -0,0 +1,82 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestIterate(t *testing.T) {
+	d := newTestDriver(t, nil)
+	// More records than one directory batch holds.
+	n := iterateBatch + 10
+	for i := 0; i < n; i++ {
+		if err := d.Write("c", fmt.Sprintf("r%03d", i), map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	err := d.Iterate("c", func(resource string, record json.RawMessage) error {
+		var doc struct{ I int }
+		if err := json.Unmarshal(record, &doc); err != nil {
+			return err
+		}
+		if want := fmt.Sprintf("r%03d", doc.I); resource != want {
+			return fmt.Errorf("record %s holds %d", resource, doc.I)
+		}
+		if seen[resource] {
+			return fmt.Errorf("%s visited twice", resource)
+		}
+		seen[resource] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != n {
+		t.Errorf("visited %d records, want %d", len(seen), n)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = d.Iterate("c", func(string, json.RawMessage) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Iterate after an error: err = %v after %d calls, want stop after 1", err, calls)
+	}
+
+	if err := d.Iterate("missing", func(string, json.RawMessage) error { return nil }); err == nil {
+		t.Error("Iterate of a missing collection succeeded")
+	}
+}
+
+func TestRegexSearchEach(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for name, doc := range map[string]string{
+		"1": `{"name": "John Smith"}`,
+		"2": `{"name": "Johnny"}`,
+		"3": `{"name": "Ann"}`,
+	} {
+		if err := d.Write("c", name, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	err := d.RegexSearchEach("c", map[string]string{"name": "^John"}, func(record map[string]interface{}) error {
+		names = append(names, record["name"].(string))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "John Smith" || names[1] != "Johnny" {
+		t.Errorf("matched %v, want John Smith and Johnny", names)
+	}
+}
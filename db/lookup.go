@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// LookupOptions describes a left outer join, as performed by Lookup and
+// the $lookup aggregation stage.
+type LookupOptions struct {
+	// From is the foreign collection to join with.
+	From string `json:"from"`
+	// LocalField is the path of the joined value in the local documents.
+	LocalField string `json:"localField"`
+	// ForeignField is the path of the value in the foreign documents that
+	// must equal the local one; "_id" stands for the resource name.
+	ForeignField string `json:"foreignField"`
+	// As names the field that receives the array of matching foreign
+	// documents.
+	As string `json:"as"`
+}
+
+// Lookup returns every document of collection with the documents of
+// opts.From whose opts.ForeignField equals its opts.LocalField embedded as
+// an array in opts.As. A local document without matches gets an empty
+// array. The foreign collection is read once into a hash table, so the
+// join costs one pass over each collection.
+func (d *Driver) Lookup(collection string, opts LookupOptions) ([]map[string]interface{}, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("Missing collection - unable to join")
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	stage, err := d.lookupStage(opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.runPipeline(collection, map[string]interface{}{}, nil, []pipelineStage{stage})
+}
+
+func (o *LookupOptions) validate() error {
+	if o.From == "" || o.LocalField == "" || o.ForeignField == "" || o.As == "" {
+		return invalidQuery("$lookup requires from, localField, foreignField and as")
+	}
+	return nil
+}
+
+// parseLookup reads the operand of a $lookup stage.
+func parseLookup(arg interface{}) (LookupOptions, error) {
+	var opts LookupOptions
+	spec, ok := arg.(map[string]interface{})
+	if !ok {
+		return opts, invalidQuery("$lookup expects an object")
+	}
+	for key, dst := range map[string]*string{
+		"from":         &opts.From,
+		"localField":   &opts.LocalField,
+		"foreignField": &opts.ForeignField,
+		"as":           &opts.As,
+	} {
+		if v, ok := spec[key]; ok {
+			if *dst, ok = v.(string); !ok {
+				return opts, invalidQuery("$lookup %s must be a string", key)
+			}
+		}
+	}
+	return opts, opts.validate()
+}
+
+// joinKeys returns the hash keys of the values at path: one per element of
+// an array, and null for a missing field, so that equal values land in the
+// same bucket whatever array they sit in.
+func joinKeys(doc map[string]interface{}, path string) []string {
+	var values []interface{}
+	for _, v := range fieldValues(doc, path) {
+		if arr, ok := v.([]interface{}); ok && len(arr) > 0 {
+			values = append(values, arr...)
+		} else {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		values = []interface{}{nil}
+	}
+
+	keys := make([]string, len(values))
+	for i, v := range values {
+		keys[i] = valueKey(v)
+	}
+	return keys
+}
+
+// lookupStage reads the foreign collection into a hash table from join key
+// to documents and returns a stage that probes it for every local document.
+func (d *Driver) lookupStage(opts LookupOptions) (pipelineStage, error) {
+	table := make(map[string][]int)
+	var foreign []map[string]interface{}
+
+	resources, err := d.resources(opts.From)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = d.eachRecord(opts.From, resources, nil, func(resource string, doc map[string]interface{}) bool {
+		var keys []string
+		if opts.ForeignField == "_id" {
+			keys = []string{valueKey(resource)}
+		} else {
+			keys = joinKeys(doc, opts.ForeignField)
+		}
+		for _, key := range keys {
+			table[key] = append(table[key], len(foreign))
+		}
+		foreign = append(foreign, doc)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(docs []map[string]interface{}) []map[string]interface{} {
+		for _, doc := range docs {
+			seen := make(map[int]bool)
+			var hits []int
+			for _, key := range joinKeys(doc, opts.LocalField) {
+				for _, i := range table[key] {
+					if !seen[i] {
+						seen[i] = true
+						hits = append(hits, i)
+					}
+				}
+			}
+			// Keep the foreign documents in resource name order.
+			sort.Ints(hits)
+
+			matches := make([]interface{}, len(hits))
+			for j, i := range hits {
+				matches[j] = foreign[i]
+			}
+			setField(doc, opts.As, matches)
+		}
+		return docs
+	}, nil
+}
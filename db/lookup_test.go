@@ -0,0 +1,104 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, w := range []struct{ collection, resource, doc string }{
+		{"customers", "c1", `{"name": "ann", "region": "north"}`},
+		{"customers", "c2", `{"name": "bob", "region": ["south", "north"]}`},
+		{"customers", "c3", `{"name": "cat", "region": "west"}`},
+		{"orders", "o1", `{"customer": "c1", "region": "north", "amount": 10}`},
+		{"orders", "o2", `{"customer": "c2", "region": "south", "amount": 20}`},
+		{"orders", "o3", `{"customer": "c9", "amount": 30}`},
+		{"orders", "o4", `{"customer": ["c1", "c3"], "region": ["west", "north"], "amount": 40}`},
+	} {
+		if err := d.Write(w.collection, w.resource, json.RawMessage(w.doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts LookupOptions
+		want string
+	}{
+		{"by resource name", LookupOptions{From: "customers", LocalField: "customer", ForeignField: "_id", As: "who"}, `[
+			{"_id": "o1", "customer": "c1", "region": "north", "amount": 10, "who": [{"_id": "c1", "name": "ann", "region": "north"}]},
+			{"_id": "o2", "customer": "c2", "region": "south", "amount": 20, "who": [{"_id": "c2", "name": "bob", "region": ["south", "north"]}]},
+			{"_id": "o3", "customer": "c9", "amount": 30, "who": []},
+			{"_id": "o4", "customer": ["c1", "c3"], "region": ["west", "north"], "amount": 40, "who": [{"_id": "c1", "name": "ann", "region": "north"}, {"_id": "c3", "name": "cat", "region": "west"}]}]`},
+		{"by field, through arrays on both sides", LookupOptions{From: "customers", LocalField: "region", ForeignField: "region", As: "near.names"}, `[
+			{"_id": "o1", "customer": "c1", "region": "north", "amount": 10, "near": {"names": [{"_id": "c1", "name": "ann", "region": "north"}, {"_id": "c2", "name": "bob", "region": ["south", "north"]}]}},
+			{"_id": "o2", "customer": "c2", "region": "south", "amount": 20, "near": {"names": [{"_id": "c2", "name": "bob", "region": ["south", "north"]}]}},
+			{"_id": "o3", "customer": "c9", "amount": 30, "near": {"names": []}},
+			{"_id": "o4", "customer": ["c1", "c3"], "region": ["west", "north"], "amount": 40, "near": {"names": [{"_id": "c1", "name": "ann", "region": "north"}, {"_id": "c2", "name": "bob", "region": ["south", "north"]}, {"_id": "c3", "name": "cat", "region": "west"}]}}]`},
+		{"missing fields join on null", LookupOptions{From: "orders", LocalField: "zone", ForeignField: "region", As: "o"}, `[
+			{"_id": "c1", "name": "ann", "region": "north", "o": [{"_id": "o3", "customer": "c9", "amount": 30}]},
+			{"_id": "c2", "name": "bob", "region": ["south", "north"], "o": [{"_id": "o3", "customer": "c9", "amount": 30}]},
+			{"_id": "c3", "name": "cat", "region": "west", "o": [{"_id": "o3", "customer": "c9", "amount": 30}]}]`},
+		{"missing foreign collection", LookupOptions{From: "nobody", LocalField: "customer", ForeignField: "_id", As: "who"}, `[
+			{"_id": "o1", "customer": "c1", "region": "north", "amount": 10, "who": []},
+			{"_id": "o2", "customer": "c2", "region": "south", "amount": 20, "who": []},
+			{"_id": "o3", "customer": "c9", "amount": 30, "who": []},
+			{"_id": "o4", "customer": ["c1", "c3"], "region": ["west", "north"], "amount": 40, "who": []}]`},
+	}
+	for _, tt := range tests {
+		local := "orders"
+		if tt.opts.From == "orders" {
+			local = "customers"
+		}
+		docs, err := d.Lookup(local, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := json.Marshal(docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameJSON(t, string(got), tt.want) {
+			t.Errorf("%s: got %s", tt.name, got)
+		}
+	}
+
+	// The $lookup stage joins the documents that reach it.
+	got, err := aggregate(t, d, `[
+		{"$match": {"amount": {"$gte": 20}}},
+		{"$lookup": {"from": "customers", "localField": "customer", "foreignField": "_id", "as": "who"}},
+		{"$unwind": "$who"},
+		{"$project": {"name": "$who.name", "amount": 1}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"_id": "o2", "name": "bob", "amount": 20}, {"_id": "o4", "name": "ann", "amount": 40}, {"_id": "o4", "name": "cat", "amount": 40}]`; !sameJSON(t, got, want) {
+		t.Errorf("$lookup stage: got %s, want %s", got, want)
+	}
+}
+
+func TestLookupRejects(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, opts := range []LookupOptions{
+		{LocalField: "a", ForeignField: "b", As: "c"},
+		{From: "x", ForeignField: "b", As: "c"},
+		{From: "x", LocalField: "a", As: "c"},
+		{From: "x", LocalField: "a", ForeignField: "b"},
+	} {
+		if _, err := d.Lookup("orders", opts); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("Lookup(%+v): err = %v, want ErrInvalidQuery", opts, err)
+		}
+	}
+	for _, pipeline := range []string{
+		`[{"$lookup": "customers"}]`,
+		`[{"$lookup": {"from": "customers", "localField": "a", "foreignField": 1, "as": "c"}}]`,
+		`[{"$lookup": {"from": "customers", "localField": "a", "as": "c"}}]`,
+		`[{"$lookup": {"from": "customers", "localField": "a", "foreignField": "b", "as": "c"}}, {"$bad": 1}]`,
+	} {
+		if _, err := aggregate(t, d, pipeline); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: err = %v, want ErrInvalidQuery", pipeline, err)
+		}
+	}
+}
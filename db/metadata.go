@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Fields that Options.Metadata makes writes maintain in every document.
+const (
+	createdField = "_created" // when the document was first written
+	updatedField = "_updated" // when it was last written
+	revField     = "_rev"     // how many times it has been written
+)
+
+// stamp sets the fields the driver maintains in the documents written by
+// ops: "_id" always, and the metadata fields when Options.Metadata is set.
+// A document whose "_id" differs from its resource name is rejected with
+// ErrInvalidID. The fields are set in the encoded records, so the other
+// fields keep their order and exact values; a record that already has
+// them is stored as it is, as are documents that are not JSON objects.
+// The caller must hold the collection mutexes.
+func (d *Driver) stamp(ops []walOp) error {
+	now, _ := json.Marshal(time.Now().UTC().Format(time.RFC3339Nano))
+
+	// written holds the records written by earlier ops of the same commit,
+	// nil for deleted ones, so that a document written twice in a
+	// transaction counts both writes.
+	type key struct{ collection, resource string }
+	written := make(map[key][]byte)
+	cleared := make(map[string]bool)
+
+	for i, op := range ops {
+		k := key{op.Collection, op.Resource}
+		switch op.Kind {
+		case opDelete:
+			written[k] = nil
+			continue
+		case opDeleteAll:
+			cleared[op.Collection] = true
+			for k := range written {
+				if k.collection == op.Collection {
+					delete(written, k)
+				}
+			}
+			continue
+		}
+
+		id, _ := json.Marshal(op.Resource)
+		fields := []rawField{{idField, id}}
+		if d.metadata {
+			prev, ok := written[k]
+			if !ok && !cleared[op.Collection] {
+				var err error
+				if prev, err = d.previous(op.Collection, op.Resource); err != nil {
+					return err
+				}
+			}
+
+			created, rev := now, 0.0
+			if spans, ok := objectFields(prev); ok {
+				if span, ok := spans[createdField]; ok {
+					var s string
+					if json.Unmarshal(prev[span[0]:span[1]], &s) == nil {
+						created = prev[span[0]:span[1]]
+					}
+				}
+				if span, ok := spans[revField]; ok {
+					json.Unmarshal(prev[span[0]:span[1]], &rev)
+				}
+			}
+			next, _ := json.Marshal(rev + 1)
+			fields = append(fields,
+				rawField{createdField, created},
+				rawField{updatedField, now},
+				rawField{revField, next})
+		}
+
+		if spans, ok := objectFields(op.Data); ok {
+			if span, ok := spans[idField]; ok && !rawEqual(op.Data[span[0]:span[1]], id) {
+				return fmt.Errorf("%w: %s/%s has _id %s", ErrInvalidID, op.Collection, op.Resource, op.Data[span[0]:span[1]])
+			}
+		}
+
+		b, ok, err := setFields(op.Data, fields)
+		if err != nil {
+			return err
+		}
+		if ok {
+			ops[i].Data = b
+		}
+		written[k] = ops[i].Data
+	}
+	return nil
+}
+
+// previous returns the stored record that a write of collection/resource
+// replaces, or nil if there is none or it has expired.
+func (d *Driver) previous(collection, resource string) ([]byte, error) {
+	b, err := d.readRaw(collection, resource)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return b, err
+}
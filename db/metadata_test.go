@@ -0,0 +1,161 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metadataOf reads the metadata fields of collection/resource.
+func metadataOf(t *testing.T, d *Driver, collection, resource string) (created, updated string, rev float64) {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := d.Read(collection, resource, &doc); err != nil {
+		t.Fatal(err)
+	}
+	created, _ = doc[createdField].(string)
+	updated, _ = doc[updatedField].(string)
+	rev, _ = doc[revField].(float64)
+	return created, updated, rev
+}
+
+func TestMetadata(t *testing.T) {
+	d := newTestDriver(t, &Options{Metadata: true})
+	forged := map[string]interface{}{"n": 1, "_created": "then", "_rev": 41}
+
+	tests := []struct {
+		name  string
+		write func() error
+		rev   float64
+		// Whether _created is kept from the previous write.
+		keep bool
+	}{
+		{"first write", func() error { return d.Write("c", "r", forged) }, 1, false},
+		{"rewrite", func() error { return d.Write("c", "r", map[string]interface{}{"n": 2}) }, 2, true},
+		{"update", func() error {
+			_, err := d.Update("c", "r", map[string]interface{}{"$inc": map[string]interface{}{"n": 1}})
+			return err
+		}, 3, true},
+		{"written twice in a transaction", func() error {
+			return d.Txn(func(tx *Tx) error {
+				tx.Write("c", "r", map[string]interface{}{"n": 4})
+				return tx.Write("c", "r", map[string]interface{}{"n": 5})
+			})
+		}, 5, true},
+		{"deleted and written in a transaction", func() error {
+			return d.Txn(func(tx *Tx) error {
+				tx.Delete("c", "r")
+				return tx.Write("c", "r", map[string]interface{}{"n": 6})
+			})
+		}, 1, false},
+		{"written after the collection was cleared", func() error {
+			if err := d.DeleteAll("c"); err != nil {
+				return err
+			}
+			return d.Write("c", "r", map[string]interface{}{"n": 7})
+		}, 1, false},
+	}
+	var created, updated string
+	for _, tt := range tests {
+		time.Sleep(time.Millisecond)
+		if err := tt.write(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		c, u, rev := metadataOf(t, d, "c", "r")
+		if rev != tt.rev {
+			t.Errorf("%s: _rev %v, want %v", tt.name, rev, tt.rev)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, c); err != nil {
+			t.Errorf("%s: _created %q: %v", tt.name, c, err)
+		}
+		if tt.keep != (c == created) {
+			t.Errorf("%s: _created %q after %q, want kept %v", tt.name, c, created, tt.keep)
+		}
+		if u <= updated || (!tt.keep && u != c) {
+			t.Errorf("%s: _updated %q after %q, _created %q", tt.name, u, updated, c)
+		}
+		created, updated = c, u
+	}
+
+	// Without Options.Metadata only _id is stored.
+	plain := newTestDriver(t, nil)
+	if err := plain.Write("c", "r", forged); err != nil {
+		t.Fatal(err)
+	}
+	b, err := plain.readStored("c", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc[idField] != "r" || doc[createdField] != "then" || doc[updatedField] != nil || doc[revField] != 41.0 {
+		t.Errorf("stored without metadata: %v", doc)
+	}
+}
+
+func TestWriteKeepsFieldOrderAndNumbers(t *testing.T) {
+	d := newTestDriver(t, &Options{Metadata: true})
+
+	body := json.RawMessage(`{"z": 1, "big": 9007199254740993, "a": {"n": 1.50}}`)
+	for i := 0; i < 2; i++ {
+		if err := d.Write("c", "r", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := d.readStored("c", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := string(b)
+	for _, want := range []string{`"big": 9007199254740993`, `"n": 1.50`, `"_rev": 2`, `"_id": "r"`} {
+		if !strings.Contains(stored, want) {
+			t.Errorf("stored record lacks %s:\n%s", want, stored)
+		}
+	}
+	if strings.Index(stored, `"z"`) > strings.Index(stored, `"big"`) {
+		t.Errorf("field order changed:\n%s", stored)
+	}
+}
+
+func TestSetFieldsUnchanged(t *testing.T) {
+	b := []byte("{\n\t\"_id\": \"r\",\n\t\"a\": 1\n}\n")
+	out, changed, err := setFields(b, []rawField{{idField, []byte(`"r"`)}})
+	if err != nil || changed || string(out) != string(b) {
+		t.Fatalf("setFields = %q, %v, %v; want the record unchanged", out, changed, err)
+	}
+}
+
+func TestWriteRejectsOtherID(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	tests := []struct {
+		name string
+		v    interface{}
+		err  error
+	}{
+		{"another _id", map[string]interface{}{"_id": "other"}, ErrInvalidID},
+		{"a number _id", json.RawMessage(`{"_id": 1}`), ErrInvalidID},
+		{"its own _id", map[string]interface{}{"_id": "z", "n": 1}, nil},
+		{"no _id", map[string]interface{}{"n": 2}, nil},
+	}
+	for _, tt := range tests {
+		if err := d.Write("c", "z", tt.v); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+	err := d.Txn(func(tx *Tx) error {
+		return tx.Write("c", "y", map[string]interface{}{"_id": "z"})
+	})
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Txn: %v, want ErrInvalidID", err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("c", "z", &doc); err != nil || doc[idField] != "z" || doc["n"] != 2.0 {
+		t.Errorf("Read = %v, %v", doc, err)
+	}
+}
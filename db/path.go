@@ -0,0 +1,99 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// getField returns the value stored at a dot-separated path such as
+// "address.city".
+func getField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// fieldValues returns every value reachable through a dot-separated path
+// the way queries see it. A numeric part such as the 0 in "items.0.sku"
+// selects an array element; any other part applied to an array is looked
+// up in each object element, so "items.sku" yields the sku of every item.
+// The result is empty if the path does not exist.
+func fieldValues(doc map[string]interface{}, path string) []interface{} {
+	return collectValues(doc, strings.Split(path, "."), nil)
+}
+
+func collectValues(cur interface{}, parts []string, out []interface{}) []interface{} {
+	if len(parts) == 0 {
+		return append(out, cur)
+	}
+
+	switch v := cur.(type) {
+	case map[string]interface{}:
+		if next, ok := v[parts[0]]; ok {
+			out = collectValues(next, parts[1:], out)
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(v) {
+				out = collectValues(v[i], parts[1:], out)
+			}
+			return out
+		}
+		for _, elem := range v {
+			if m, ok := elem.(map[string]interface{}); ok {
+				out = collectValues(m, parts, out)
+			}
+		}
+	}
+	return out
+}
+
+// setField stores v at path, creating intermediate objects as needed.
+func setField(doc map[string]interface{}, path string, v interface{}) error {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part]
+		if !ok || next == nil {
+			m := make(map[string]interface{})
+			cur[part] = m
+			cur = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot create field %q in non-object element of %q", part, path)
+		}
+		cur = m
+	}
+	cur[parts[len(parts)-1]] = v
+	return nil
+}
+
+// unsetField removes the value at path and returns it.
+func unsetField(doc map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		m, ok := cur[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur = m
+	}
+	last := parts[len(parts)-1]
+	v, ok := cur[last]
+	if ok {
+		delete(cur, last)
+	}
+	return v, ok
+}
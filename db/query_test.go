@@ -0,0 +1,199 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// decode unmarshals a JSON object written inline in a test.
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatalf("%s: %v", s, err)
+	}
+	return m
+}
+
+func TestMatchesQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		record string
+		want   bool
+	}{
+		{`{}`, `{"a": 1}`, true},
+		{`{"a": 1}`, `{"a": 1}`, true},
+		{`{"a": 1}`, `{"a": 2}`, false},
+		{`{"a": 1}`, `{"b": 1}`, false},
+		{`{"a": 1, "b": 2}`, `{"a": 1, "b": 3}`, false},
+		{`{"a": {"$eq": 1}}`, `{"a": 1}`, true},
+		{`{"a": {"$gt": 1, "$lt": 3}}`, `{"a": 2}`, true},
+		{`{"a": {"$gt": 1, "$lt": 3}}`, `{"a": 3}`, false},
+		{`{"a": {"$gt": 1}}`, `{"a": "2"}`, false},
+		{`{"a": {"$in": [1, 2]}}`, `{"a": 2}`, true},
+		{`{"a": {"b": 1}}`, `{"a": {"b": 1}}`, true},
+
+		{`{"$and": [{"a": 1}, {"b": 2}]}`, `{"a": 1, "b": 2}`, true},
+		{`{"$and": [{"a": 1}, {"b": 2}]}`, `{"a": 1, "b": 3}`, false},
+		{`{"$or": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 2}`, true},
+		{`{"$or": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 0}`, false},
+		{`{"$nor": [{"a": 1}, {"b": 2}]}`, `{"a": 0, "b": 0}`, true},
+		{`{"$nor": [{"a": 1}, {"b": 2}]}`, `{"a": 1}`, false},
+		{`{"$or": [{"a": 1}, {"$and": [{"b": 2}, {"c": 3}]}]}`, `{"b": 2, "c": 3}`, true},
+		{`{"$or": [{"a": 1}, {"$and": [{"b": 2}, {"c": 3}]}]}`, `{"b": 2, "c": 4}`, false},
+		{`{"a": 1, "$or": [{"b": 1}, {"c": 1}]}`, `{"a": 2, "b": 1}`, false},
+		{`{"a": {"$not": {"$gt": 5}}}`, `{"a": 3}`, true},
+		{`{"a": {"$not": {"$gt": 5}}}`, `{"a": 6}`, false},
+		{`{"a": {"$not": {"$gt": 5}}}`, `{"b": 6}`, true},
+
+		{`{"a.b": 1}`, `{"a": {"b": 1}}`, true},
+		{`{"a.b": 1}`, `{"a": {"c": 1}}`, false},
+		{`{"a.b": 1}`, `{"a": 1}`, false},
+		{`{"a.b.c": {"$gt": 1}}`, `{"a": {"b": {"c": 2}}}`, true},
+		{`{"tags": "x"}`, `{"tags": ["w", "x"]}`, true},
+		{`{"tags": "x"}`, `{"tags": ["w", "y"]}`, false},
+		{`{"tags": ["w", "x"]}`, `{"tags": ["w", "x"]}`, true},
+		{`{"tags": {"$in": ["x", "z"]}}`, `{"tags": ["w", "x"]}`, true},
+		{`{"tags": {"$ne": "x"}}`, `{"tags": ["w", "x"]}`, false},
+		{`{"tags": {"$ne": "x"}}`, `{"tags": ["w", "y"]}`, true},
+		{`{"scores": {"$gt": 8}}`, `{"scores": [3, 9]}`, true},
+		{`{"scores": {"$gt": 8}}`, `{"scores": [3, 5]}`, false},
+		{`{"items.0.sku": "a"}`, `{"items": [{"sku": "a"}, {"sku": "b"}]}`, true},
+		{`{"items.1.sku": "a"}`, `{"items": [{"sku": "a"}, {"sku": "b"}]}`, false},
+		{`{"items.2.sku": "a"}`, `{"items": [{"sku": "a"}]}`, false},
+		{`{"items.sku": "b"}`, `{"items": [{"sku": "a"}, {"sku": "b"}]}`, true},
+		{`{"items.sku": "c"}`, `{"items": [{"sku": "a"}, {"sku": "b"}]}`, false},
+		{`{"items.qty": {"$gte": 5}}`, `{"items": [{"qty": 1}, {"qty": 5}]}`, true},
+		{`{"$or": [{"a.b": 2}, {"tags": "x"}]}`, `{"a": {"b": 1}, "tags": ["x"]}`, true},
+
+		{`{"a": {"$gt": 25}}`, `{"a": "30"}`, false},
+		{`{"a": {"$lt": 25}}`, `{"a": null}`, false},
+		{`{"a": {"$lt": "m"}}`, `{"a": "apple"}`, true},
+		{`{"a": {"$lt": "m"}}`, `{"a": "zebra"}`, false},
+		{`{"a": {"$lt": "m"}}`, `{"a": 1}`, false},
+		{`{"a": {"$gte": "10"}}`, `{"a": "9"}`, true},
+		{`{"a": {"$gt": false}}`, `{"a": true}`, true},
+		{`{"a": {"$gt": [1]}}`, `{"a": [[1, 2]]}`, true},
+		{`{"a": "1"}`, `{"a": 1}`, false},
+		{`{"a": null}`, `{"a": null}`, true},
+		{`{"a": {"$date": "2024-01-02T00:00:00Z"}}`, `{"a": "2024-01-02T00:00:00Z"}`, true},
+		{`{"a": {"$date": 1704153600000}}`, `{"a": "2024-01-02T00:00:00Z"}`, true},
+		{`{"a": {"$gt": {"$date": "2024-01-01T00:00:00Z"}}}`, `{"a": "2024-01-02T00:00:00Z"}`, true},
+		{`{"a": {"$gt": {"$date": "2024-01-01T00:00:00Z"}}}`, `{"a": "2023-12-31T23:59:59.5Z"}`, false},
+		{`{"a": {"$lt": {"$date": "2024-01-01T00:00:00Z"}}}`, `{"a": "yesterday"}`, false},
+		{`{"a": {"$in": [{"$date": "2024-01-02T00:00:00Z"}]}}`, `{"a": "2024-01-02T00:00:00Z"}`, true},
+
+		{`{"a": {"$ne": 1}}`, `{"b": 1}`, true},
+		{`{"a": {"$ne": 1}}`, `{"a": 2}`, true},
+		{`{"a": {"$ne": 1}}`, `{"a": 1}`, false},
+		{`{"a": {"$ne": 1}}`, `{"a": [1, 2]}`, false},
+		{`{"a.b": {"$ne": 1}}`, `{"a": {}}`, true},
+		{`{"a": {"$nin": [1, 2]}}`, `{"b": 1}`, true},
+		{`{"a": {"$nin": [1, 2]}}`, `{"a": 3}`, true},
+		{`{"a": {"$nin": [1, 2]}}`, `{"a": 2}`, false},
+		{`{"a.b": {"$nin": [1, 2]}}`, `{"a": {}}`, true},
+		{`{"a": {"$exists": true}}`, `{"a": null}`, true},
+		{`{"a": {"$exists": true}}`, `{"b": 1}`, false},
+		{`{"a": {"$exists": false}}`, `{"b": 1}`, true},
+		{`{"a.b": {"$exists": true}}`, `{"a": [{"c": 1}, {"b": 1}]}`, true},
+		{`{"tags": {"$all": ["x", "y"]}}`, `{"tags": ["y", "z", "x"]}`, true},
+		{`{"tags": {"$all": ["x", "y"]}}`, `{"tags": ["x", "z"]}`, false},
+		{`{"tags": {"$all": []}}`, `{"tags": ["x"]}`, false},
+		{`{"tags": {"$size": 2}}`, `{"tags": ["x", "y"]}`, true},
+		{`{"tags": {"$size": 2}}`, `{"tags": ["x"]}`, false},
+		{`{"tags": {"$size": 0}}`, `{"tags": "x"}`, false},
+		{`{"a": {"$type": "string"}}`, `{"a": "1"}`, true},
+		{`{"a": {"$type": "string"}}`, `{"a": 1}`, false},
+		{`{"a": {"$type": ["null", "number"]}}`, `{"a": null}`, true},
+		{`{"a": {"$type": 2}}`, `{"a": "1"}`, true},
+		{`{"a": {"$type": "array"}}`, `{"a": []}`, true},
+		{`{"a": {"$mod": [4, 1]}}`, `{"a": 9}`, true},
+		{`{"a": {"$mod": [4, 1]}}`, `{"a": 8}`, false},
+		{`{"a": {"$mod": [4, 1]}}`, `{"a": "9"}`, false},
+		{`{"a": {"$regex": "^sa"}}`, `{"a": "sam"}`, true},
+		{`{"a": {"$regex": "^sa"}}`, `{"a": "Sam"}`, false},
+		{`{"a": {"$regex": "^sa", "$options": "i"}}`, `{"a": "Sam"}`, true},
+		{`{"a": {"$regex": "^1"}}`, `{"a": 12}`, false},
+		{`{"tags": {"$regex": "^v"}}`, `{"tags": ["new", "vip"]}`, true},
+		{`{"items": {"$elemMatch": {"sku": "s1", "qty": {"$gt": 2}}}}`, `{"items": [{"sku": "s1", "qty": 3}]}`, true},
+		{`{"items": {"$elemMatch": {"sku": "s1", "qty": {"$gt": 2}}}}`, `{"items": [{"sku": "s1", "qty": 1}, {"sku": "s2", "qty": 3}]}`, false},
+		{`{"scores": {"$elemMatch": {"$gte": 80, "$lt": 85}}}`, `{"scores": [79, 90, 82]}`, true},
+		{`{"scores": {"$elemMatch": {"$gte": 80, "$lt": 85}}}`, `{"scores": [79, 90]}`, false},
+		{`{"scores": {"$gte": 80, "$lt": 85}}`, `{"scores": [79, 90]}`, true},
+	}
+	for _, tt := range tests {
+		got, err := matchesQuery(decode(t, tt.record), decode(t, tt.query))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s on %s = %v, want %v", tt.query, tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	for _, query := range []string{
+		`{"$xor": [{"a": 1}]}`,
+		`{"$or": []}`,
+		`{"$or": {"a": 1}}`,
+		`{"$and": [1]}`,
+		`{"a": {"$regexp": "x"}}`,
+		`{"a": {"$gt": 1, "b": 2}}`,
+		`{"a": {"$in": 1}}`,
+		`{"a": {"$not": 5}}`,
+		`{"a": {"$not": {"b": 1}}}`,
+		`{"$or": [{"a": {"$bad": 1}}]}`,
+		`{"a": {"$date": "tomorrow"}}`,
+		`{"a": {"$gt": {"$date": true}}}`,
+		`{"a": {"$nin": 1}}`,
+		`{"a": {"$exists": 1}}`,
+		`{"a": {"$all": "x"}}`,
+		`{"a": {"$size": -1}}`,
+		`{"a": {"$size": 1.5}}`,
+		`{"a": {"$type": "uuid"}}`,
+		`{"a": {"$mod": [0, 1]}}`,
+		`{"a": {"$mod": [2]}}`,
+		`{"a": {"$regex": "("}}`,
+		`{"a": {"$regex": "x", "$options": "g"}}`,
+		`{"a": {"$options": "i"}}`,
+		`{"a": {"$elemMatch": 1}}`,
+	} {
+		if _, err := compileQuery(decode(t, query)); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: err = %v, want ErrInvalidQuery", query, err)
+		}
+	}
+}
+
+func TestRegexSearchPaths(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for name, doc := range map[string]string{
+		"1": `{"address": {"city": "Austin"}, "tags": ["vip", "new"]}`,
+		"2": `{"address": {"city": "Boston"}, "tags": ["new"]}`,
+	} {
+		if err := d.Write("c", name, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query map[string]string
+		want  int
+	}{
+		{map[string]string{"address.city": "^Aus"}, 1},
+		{map[string]string{"address.city": "o"}, 1},
+		{map[string]string{"tags": "^new$"}, 2},
+		{map[string]string{"tags": "^vip$", "address.city": "Bos"}, 0},
+		{map[string]string{"address.zip": "."}, 0},
+	}
+	for _, tt := range tests {
+		records, err := d.RegexSearch("c", tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != tt.want {
+			t.Errorf("%v: %d records, want %d", tt.query, len(records), tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// rawField is a top-level field of an encoded object and its encoded
+// value.
+type rawField struct {
+	name  string
+	value []byte
+}
+
+// objectFields finds the encoded value of every top-level field of the
+// encoded object b, as start and end offsets into b. ok is false when b is
+// not a JSON object.
+func objectFields(b []byte) (spans map[string][2]int, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+
+	spans = make(map[string][2]int)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		end := int(dec.InputOffset())
+		spans[tok.(string)] = [2]int{end - len(value), end}
+	}
+	return spans, true
+}
+
+// setFields returns the encoded object b with fields set to their values.
+// Fields b already has are replaced where they are and missing ones are
+// added first, so the other fields keep their order and their values are
+// not decoded. changed is false, and b returned as it is, when b is not a
+// JSON object or already holds every value.
+func setFields(b []byte, fields []rawField) (out []byte, changed bool, err error) {
+	spans, ok := objectFields(b)
+	if !ok {
+		return b, false, nil
+	}
+
+	type edit struct {
+		start, end int
+		value      []byte
+	}
+	var edits []edit
+	var added []rawField
+	for _, f := range fields {
+		span, ok := spans[f.name]
+		if !ok {
+			added = append(added, f)
+			continue
+		}
+		if !rawEqual(b[span[0]:span[1]], f.value) {
+			edits = append(edits, edit{span[0], span[1], f.value})
+		}
+	}
+	if len(edits) == 0 && len(added) == 0 {
+		return b, false, nil
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+
+	open := bytes.IndexByte(b, '{') + 1
+	var buf bytes.Buffer
+	buf.Write(b[:open])
+	for _, f := range added {
+		name, _ := json.Marshal(f.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(f.value)
+		buf.WriteByte(',')
+	}
+	if len(added) > 0 && len(spans) == 0 {
+		buf.Truncate(buf.Len() - 1)
+	}
+	pos := open
+	for _, e := range edits {
+		buf.Write(b[pos:e.start])
+		buf.Write(e.value)
+		pos = e.end
+	}
+	buf.Write(b[pos:])
+
+	// Lay the record out as encode does.
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, bytes.TrimSpace(buf.Bytes()), "", "\t"); err != nil {
+		return nil, false, err
+	}
+	indented.WriteByte('\n')
+	return indented.Bytes(), true, nil
+}
+
+// rawEqual reports whether an encoded value is encoded as value once its
+// whitespace is removed.
+func rawEqual(encoded, value []byte) bool {
+	var compact bytes.Buffer
+	return json.Compact(&compact, encoded) == nil && bytes.Equal(compact.Bytes(), value)
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// revision derives the revision of a stored record from its contents, so
+// every change to a document yields a new revision without any extra
+// bookkeeping on disk.
+func revision(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ReadRev reads collection/resource into v and returns the revision of the
+// bytes that were decoded.
+func (d *Driver) ReadRev(collection, resource string, v interface{}) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("Missing collection - unable to read!")
+	}
+
+	if resource == "" {
+		return "", fmt.Errorf("Missing resource - unable to read record (no name)!")
+	}
+
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return "", err
+	}
+
+	if err := json.Unmarshal(rawWithID(b, resource), v); err != nil {
+		return "", err
+	}
+	return revision(b), nil
+}
+
+// Rev returns the current revision of collection/resource.
+func (d *Driver) Rev(collection, resource string) (string, error) {
+	b, err := d.readRaw(collection, resource)
+	if err != nil {
+		return "", err
+	}
+	return revision(b), nil
+}
+
+// WriteIf stores v as collection/resource only if the stored revision is
+// still expectedRev, and returns the new revision. An empty expectedRev
+// requires the resource not to exist yet. On a mismatch nothing is written
+// and ErrRevisionMismatch is returned.
+func (d *Driver) WriteIf(collection, resource, expectedRev string, v interface{}) (string, error) {
+	if collection == "" {
+		return "", fmt.Errorf("Missing collection - no place to save record!")
+	}
+
+	if resource == "" {
+		return "", fmt.Errorf("Missing resource - unable to save record (no name)!")
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	current := ""
+	b, err := d.readRaw(collection, resource)
+	switch {
+	case err == nil:
+		current = revision(b)
+	case !errors.Is(err, ErrNotFound):
+		return "", err
+	}
+
+	if current != expectedRev {
+		return "", fmt.Errorf("%w: %s/%s is at revision %q, expected %q",
+			ErrRevisionMismatch, collection, resource, current, expectedRev)
+	}
+
+	return d.write(collection, resource, v)
+}
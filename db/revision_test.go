@@ -0,0 +1,47 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteIf(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	if _, err := d.WriteIf("c", "r", "0123456789abcdef", map[string]interface{}{"n": 1}); !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("expecting a revision of a missing resource: err = %v, want ErrRevisionMismatch", err)
+	}
+	rev1, err := d.WriteIf("c", "r", "", map[string]interface{}{"n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.WriteIf("c", "r", "", map[string]interface{}{"n": 2}); !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("creating an existing resource: err = %v, want ErrRevisionMismatch", err)
+	}
+
+	rev2, err := d.WriteIf("c", "r", rev1, map[string]interface{}{"n": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev2 == rev1 {
+		t.Errorf("a change kept revision %s", rev1)
+	}
+	if _, err := d.WriteIf("c", "r", rev1, map[string]interface{}{"n": 3}); !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("stale revision: err = %v, want ErrRevisionMismatch", err)
+	}
+
+	var doc map[string]interface{}
+	rev, err := d.ReadRev("c", "r", &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != rev2 || doc["n"] != 2.0 {
+		t.Errorf("ReadRev = %s, %v; want %s, n 2", rev, doc, rev2)
+	}
+	if rev, err := d.Rev("c", "r"); err != nil || rev != rev2 {
+		t.Errorf("Rev = %s, %v; want %s", rev, err, rev2)
+	}
+	if _, err := d.Rev("c", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rev of a missing resource: err = %v, want ErrNotFound", err)
+	}
+}
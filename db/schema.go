@@ -0,0 +1,474 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidSchema is returned by SetValidator for a schema that is not
+// valid JSON Schema or uses keywords that are not supported.
+var ErrInvalidSchema = errors.New("invalid schema")
+
+func invalidSchema(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSchema, fmt.Sprintf(format, args...))
+}
+
+// ValidationLevel says how a collection enforces its schema.
+type ValidationLevel string
+
+const (
+	// ValidationStrict rejects every write of an invalid document.
+	ValidationStrict ValidationLevel = "strict"
+	// ValidationModerate rejects invalid inserts and invalid replacements
+	// of valid documents, but lets documents that were already invalid be
+	// replaced freely.
+	ValidationModerate ValidationLevel = "moderate"
+	// ValidationWarn accepts invalid documents and logs a warning.
+	ValidationWarn ValidationLevel = "warn"
+)
+
+// Validator is the JSON Schema of a collection and how it is enforced.
+type Validator struct {
+	Schema map[string]interface{} `json:"schema"`
+	Level  ValidationLevel        `json:"level"`
+}
+
+// SchemaError is one way in which a document fails a schema. Path is the
+// dot path of the offending value, empty for the document itself, and
+// Keyword the schema keyword it fails.
+type SchemaError struct {
+	Path    string `json:"path"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a write is rejected by the schema of
+// its collection.
+type ValidationError struct {
+	Collection string
+	Resource   string
+	Errors     []SchemaError
+}
+
+func (e *ValidationError) Error() string {
+	first := e.Errors[0]
+	msg := first.Message
+	if first.Path != "" {
+		msg = first.Path + ": " + msg
+	}
+	if len(e.Errors) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Errors)-1)
+	}
+	return fmt.Sprintf("document %s/%s does not match the schema: %s", e.Collection, e.Resource, msg)
+}
+
+// schema is a compiled JSON Schema. It supports the draft 2020-12
+// keywords type, enum, const, required, properties, additionalProperties,
+// items, minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength,
+// maxLength, pattern, minItems and maxItems.
+type schema struct {
+	types                              []string
+	enum                               []interface{}
+	required                           []string
+	properties                         map[string]*schema
+	additional                         *schema
+	noAdditional                       bool
+	items                              *schema
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	pattern                            *regexp.Regexp
+}
+
+// annotations are keywords that describe a schema without constraining
+// documents.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true,
+}
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+func compileSchema(v interface{}) (*schema, error) {
+	spec, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, invalidSchema("a schema must be an object")
+	}
+
+	s := &schema{}
+	for key, arg := range spec {
+		var err error
+		switch key {
+		case "type":
+			err = s.compileType(arg)
+		case "enum":
+			values, ok := arg.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, invalidSchema("enum expects a non-empty array")
+			}
+			s.enum = values
+		case "const":
+			s.enum = []interface{}{arg}
+		case "required":
+			names, ok := arg.([]interface{})
+			if !ok {
+				return nil, invalidSchema("required expects an array of property names")
+			}
+			for _, name := range names {
+				n, ok := name.(string)
+				if !ok {
+					return nil, invalidSchema("required expects an array of property names")
+				}
+				s.required = append(s.required, n)
+			}
+		case "properties":
+			props, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, invalidSchema("properties expects an object of schemas")
+			}
+			s.properties = make(map[string]*schema, len(props))
+			for name, sub := range props {
+				if s.properties[name], err = compileSchema(sub); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if b, ok := arg.(bool); ok {
+				s.noAdditional = !b
+			} else {
+				s.additional, err = compileSchema(arg)
+			}
+		case "items":
+			s.items, err = compileSchema(arg)
+		case "minimum":
+			s.minimum, err = schemaNumber(key, arg)
+		case "maximum":
+			s.maximum, err = schemaNumber(key, arg)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = schemaNumber(key, arg)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = schemaNumber(key, arg)
+		case "minLength":
+			s.minLength, err = schemaCount(key, arg)
+		case "maxLength":
+			s.maxLength, err = schemaCount(key, arg)
+		case "minItems":
+			s.minItems, err = schemaCount(key, arg)
+		case "maxItems":
+			s.maxItems, err = schemaCount(key, arg)
+		case "pattern":
+			p, ok := arg.(string)
+			if !ok {
+				return nil, invalidSchema("pattern expects a string")
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, invalidSchema("pattern %q: %v", p, err)
+			}
+		default:
+			if !annotations[key] {
+				return nil, invalidSchema("unsupported keyword %s", key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *schema) compileType(arg interface{}) error {
+	names := []interface{}{arg}
+	if arr, ok := arg.([]interface{}); ok {
+		names = arr
+	}
+	for _, name := range names {
+		t, ok := name.(string)
+		if !ok || !schemaTypes[t] {
+			return invalidSchema("unknown type %v", name)
+		}
+		s.types = append(s.types, t)
+	}
+	return nil
+}
+
+func schemaNumber(key string, arg interface{}) (*float64, error) {
+	f, ok := toFloat(arg)
+	if !ok {
+		return nil, invalidSchema("%s expects a number", key)
+	}
+	return &f, nil
+}
+
+func schemaCount(key string, arg interface{}) (*int, error) {
+	f, ok := toFloat(arg)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, invalidSchema("%s expects a non-negative integer", key)
+	}
+	n := int(f)
+	return &n, nil
+}
+
+// schemaType returns the JSON Schema type of a decoded JSON value.
+func schemaType(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+	}
+	return "number"
+}
+
+// validate appends to errs every way in which v, found at path, fails s.
+func (s *schema) validate(path string, v interface{}, errs []SchemaError) []SchemaError {
+	fail := func(keyword, format string, args ...interface{}) {
+		errs = append(errs, SchemaError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	t := schemaType(v)
+	if len(s.types) > 0 {
+		ok := false
+		for _, want := range s.types {
+			if want == t || (want == "number" && t == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("type", "expected %s, got %s", strings.Join(s.types, " or "), t)
+			// The other keywords would only repeat the mismatch.
+			return errs
+		}
+	}
+
+	if s.enum != nil {
+		ok := false
+		for _, want := range s.enum {
+			if valuesEqual(v, want) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			allowed, _ := json.Marshal(s.enum)
+			fail("enum", "must be one of %s", allowed)
+		}
+	}
+
+	switch x := v.(type) {
+	case float64:
+		if s.minimum != nil && x < *s.minimum {
+			fail("minimum", "must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && x > *s.maximum {
+			fail("maximum", "must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && x <= *s.exclusiveMinimum {
+			fail("exclusiveMinimum", "must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && x >= *s.exclusiveMaximum {
+			fail("exclusiveMaximum", "must be < %v", *s.exclusiveMaximum)
+		}
+
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength != nil && n < *s.minLength {
+			fail("minLength", "must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("maxLength", "must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			fail("pattern", "must match %q", s.pattern.String())
+		}
+
+	case []interface{}:
+		if s.minItems != nil && len(x) < *s.minItems {
+			fail("minItems", "must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(x) > *s.maxItems {
+			fail("maxItems", "must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range x {
+				errs = s.items.validate(joinPath(path, fmt.Sprint(i)), item, errs)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := x[name]; !ok {
+				errs = append(errs, SchemaError{Path: joinPath(path, name), Keyword: "required", Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sub, ok := s.properties[name]; ok {
+				errs = sub.validate(joinPath(path, name), x[name], errs)
+				continue
+			}
+			switch {
+			case s.noAdditional:
+				errs = append(errs, SchemaError{Path: joinPath(path, name), Keyword: "additionalProperties", Message: "is not allowed"})
+			case s.additional != nil:
+				errs = s.additional.validate(joinPath(path, name), x[name], errs)
+			}
+		}
+	}
+	return errs
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// driverFields are the top-level fields the driver maintains in documents.
+// Schemas do not have to allow for them.
+var driverFields = []string{idField, createdField, updatedField, revField, expiresField}
+
+// validateDocument checks an encoded record against s, leaving out the
+// driverFields.
+func (s *schema) validateDocument(b []byte) []SchemaError {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return []SchemaError{{Keyword: "type", Message: "is not valid JSON"}}
+	}
+	if doc, ok := v.(map[string]interface{}); ok {
+		for _, name := range driverFields {
+			delete(doc, name)
+		}
+	}
+	return s.validate("", v, nil)
+}
+
+// SetValidator sets the schema that writes to collection are validated
+// against, replacing any previous one; a nil v removes it. An empty level
+// means ValidationStrict. Documents already stored are not checked.
+func (d *Driver) SetValidator(collection string, v *Validator) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to set schema!")
+	}
+
+	var compiled *schema
+	if v != nil {
+		level := v.Level
+		switch level {
+		case "":
+			level = ValidationStrict
+		case ValidationStrict, ValidationModerate, ValidationWarn:
+		default:
+			return invalidSchema("unknown validation level %q (use strict, moderate or warn)", v.Level)
+		}
+		normalized, err := normalize(v.Schema)
+		if err != nil {
+			return invalidSchema("%v", err)
+		}
+		if compiled, err = compileSchema(normalized); err != nil {
+			return err
+		}
+		v = &Validator{Schema: normalized.(map[string]interface{}), Level: level}
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cs := d.getOrCreateState(collection)
+	meta := cs.meta
+	meta.Validator = v
+	if err := d.saveMeta(collection, meta); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	cs.meta = meta
+	cs.schema = compiled
+	cs.mu.Unlock()
+	return nil
+}
+
+// Validator returns the schema of collection, or nil if it has none.
+func (d *Driver) Validator(collection string) *Validator {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.meta.Validator == nil {
+		return nil
+	}
+	v := *cs.meta.Validator
+	return &v
+}
+
+// checkSchemas validates the documents written by ops against the schemas
+// of their collections. The caller must hold the collection mutexes.
+func (d *Driver) checkSchemas(ops []walOp) error {
+	for _, op := range ops {
+		if op.Kind != opWrite {
+			continue
+		}
+		cs := d.state(op.Collection)
+		if cs == nil {
+			continue
+		}
+		cs.mu.RLock()
+		s := cs.schema
+		var level ValidationLevel
+		if cs.meta.Validator != nil {
+			level = cs.meta.Validator.Level
+		}
+		cs.mu.RUnlock()
+		if s == nil {
+			continue
+		}
+
+		errs := s.validateDocument(op.Data)
+		if len(errs) == 0 {
+			continue
+		}
+		if level == ValidationModerate {
+			// Documents that were already invalid may stay so.
+			stored, err := d.readStored(op.Collection, op.Resource)
+			if err == nil && len(s.validateDocument(stored)) > 0 {
+				continue
+			}
+		}
+		verr := &ValidationError{Collection: op.Collection, Resource: op.Resource, Errors: errs}
+		if level == ValidationWarn {
+			d.log.Warn("Accepting invalid document: %s", verr)
+			continue
+		}
+		return verr
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// userSchema exercises every supported keyword.
+const userSchema = `{
+	"title": "user",
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"score": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+		"role": {"enum": ["admin", "user"]},
+		"kind": {"const": "person"},
+		"nick": {"type": ["string", "null"]},
+		"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}},
+		"meta": {"type": "object", "additionalProperties": {"type": "boolean"}}
+	},
+	"additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := compileSchema(decode(t, userSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc  string
+		want []string // path and keyword of every error
+	}{
+		{`{"name": "ann", "age": 30}`, nil},
+		{`{"name": "ann", "age": 30, "score": 0.5, "role": "admin", "kind": "person", "nick": null, "tags": ["a"], "meta": {"x": true}}`, nil},
+		{`{"name": "ann", "age": 30.0, "nick": "a"}`, nil},
+		{`{}`, []string{"name required", "age required"}},
+		{`{"name": "", "age": -1}`, []string{"age minimum", "name minLength", "name pattern"}},
+		{`{"name": "annabel", "age": 151}`, []string{"age maximum", "name maxLength"}},
+		{`{"name": "Ann", "age": 1.5}`, []string{"age type", "name pattern"}},
+		{`{"name": "ann", "age": 1, "score": 0}`, []string{"score exclusiveMinimum"}},
+		{`{"name": "ann", "age": 1, "score": 1}`, []string{"score exclusiveMaximum"}},
+		{`{"name": "ann", "age": 1, "role": "root", "kind": "robot"}`, []string{"kind enum", "role enum"}},
+		{`{"name": "ann", "age": 1, "nick": 1}`, []string{"nick type"}},
+		{`{"name": "ann", "age": 1, "tags": []}`, []string{"tags minItems"}},
+		{`{"name": "ann", "age": 1, "tags": ["a", 2, "c"]}`, []string{"tags maxItems", "tags.1 type"}},
+		{`{"name": "ann", "age": 1, "meta": {"x": 1}}`, []string{"meta.x type"}},
+		{`{"name": "ann", "age": 1, "extra": 1}`, []string{"extra additionalProperties"}},
+		{`{"name": "ann", "age": 1, "_extra": 1}`, []string{"_extra additionalProperties"}},
+		{`{"name": "ann", "age": 1, "_id": "r", "_expires": "2030-01-01T00:00:00Z"}`, nil},
+		{`{"name": "ann", "age": 1, "_created": "t", "_updated": "t", "_rev": 1}`, nil},
+		{`[1]`, []string{" type"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, e := range s.validateDocument([]byte(tt.doc)) {
+			got = append(got, e.Path+" "+e.Keyword)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: errors %q, want %q", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidSchemas(t *testing.T) {
+	d := newTestDriver(t, nil)
+	tests := []struct {
+		schema string
+		level  ValidationLevel
+	}{
+		{`{"type": "thing"}`, ""},
+		{`{"type": 1}`, ""},
+		{`{"enum": []}`, ""},
+		{`{"required": [1]}`, ""},
+		{`{"properties": {"a": 1}}`, ""},
+		{`{"properties": {"a": {"oneOf": []}}}`, ""},
+		{`{"items": true}`, ""},
+		{`{"minimum": "1"}`, ""},
+		{`{"minLength": -1}`, ""},
+		{`{"maxItems": 1.5}`, ""},
+		{`{"pattern": "("}`, ""},
+		{`{"allOf": []}`, ""},
+		{`{"type": "object"}`, "lenient"},
+	}
+	for _, tt := range tests {
+		err := d.SetValidator("c", &Validator{Schema: decode(t, tt.schema), Level: tt.level})
+		if !errors.Is(err, ErrInvalidSchema) {
+			t.Errorf("%s %s: %v, want ErrInvalidSchema", tt.schema, tt.level, err)
+		}
+	}
+	if v := d.Validator("c"); v != nil {
+		t.Errorf("an invalid schema was set: %+v", v)
+	}
+}
+
+func TestValidationLevels(t *testing.T) {
+	schema := decode(t, `{"required": ["n"], "properties": {"n": {"type": "integer"}}}`)
+	valid := map[string]interface{}{"n": 1}
+	invalid := map[string]interface{}{"n": "x"}
+
+	tests := []struct {
+		level ValidationLevel
+		// Whether inserting an invalid document, replacing a valid one
+		// with an invalid one and replacing an invalid one are accepted.
+		insert, spoil, keep bool
+	}{
+		{ValidationStrict, false, false, false},
+		{ValidationModerate, false, false, true},
+		{ValidationWarn, true, true, true},
+	}
+	for _, tt := range tests {
+		d := newTestDriver(t, nil)
+		if err := d.Write("c", "valid", valid); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("c", "invalid", invalid); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetValidator("c", &Validator{Schema: schema, Level: tt.level}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, step := range []struct {
+			what     string
+			resource string
+			ok       bool
+		}{
+			{"insert", "new", tt.insert},
+			{"spoil", "valid", tt.spoil},
+			{"keep", "invalid", tt.keep},
+		} {
+			err := d.Write("c", step.resource, invalid)
+			var verr *ValidationError
+			if step.ok && err != nil || !step.ok && !errors.As(err, &verr) {
+				t.Errorf("%s: %s: %v, want accepted %v", tt.level, step.what, err, step.ok)
+			}
+		}
+		if err := d.Write("c", "valid", valid); err != nil {
+			t.Errorf("%s: valid write: %v", tt.level, err)
+		}
+	}
+}
+
+func TestSchemaCoversEveryWrite(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetValidator("c", &Validator{Schema: decode(t, `{"properties": {"n": {"type": "integer", "maximum": 1}}}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "r", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var verr *ValidationError
+	if _, err := d.Update("c", "r", decode(t, `{"$inc": {"n": 1}}`)); !errors.As(err, &verr) {
+		t.Errorf("Update: %v, want a ValidationError", err)
+	}
+	err = d.Txn(func(tx *Tx) error {
+		tx.Write("other", "r", map[string]interface{}{"n": 5})
+		return tx.Write("c", "s", map[string]interface{}{"n": 5})
+	})
+	if !errors.As(err, &verr) || verr.Resource != "s" || verr.Errors[0].Path != "n" {
+		t.Errorf("Txn: %v, want a ValidationError for c/s", err)
+	}
+	if _, err := d.readStored("other", "r"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the rejected transaction was applied in part: %v", err)
+	}
+	if _, err := d.WriteIf("c", "w", "", json.RawMessage(`{"n": 2}`)); !errors.As(err, &verr) {
+		t.Errorf("WriteIf: %v, want a ValidationError", err)
+	}
+
+	// The schema is kept with the collection across a restart; the
+	// fields the driver maintains need no place in it.
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(dir, &Options{ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Write("c", "r", map[string]interface{}{"n": 3}); !errors.As(err, &verr) {
+		t.Errorf("after a restart: %v, want a ValidationError", err)
+	}
+	if err := d.SetValidator("c", &Validator{Schema: decode(t, `{"additionalProperties": false, "properties": {"n": {}}}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.WriteWith("c", "r", map[string]interface{}{"n": 3}, &WriteOptions{ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Errorf("_expires was validated: %v", err)
+	}
+	if err := d.Write("c", "r", map[string]interface{}{"n": 3, "_evil": 1}); !errors.As(err, &verr) {
+		t.Errorf("a field starting with _ bypassed the schema: %v", err)
+	}
+
+	if err := d.SetValidator("c", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "r", map[string]interface{}{"n": "x", "_evil": 1}); err != nil {
+		t.Errorf("without a schema: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// SearchOptions scopes and shapes a call to Search.
+type SearchOptions struct {
+	// Collections limits the search to the named collections. When empty,
+	// every collection in the database is searched.
+	Collections []string
+	// Documents asks for the matching documents as well as their names.
+	Documents bool
+	// FindOptions sorts and pages the records and shapes their documents.
+	FindOptions
+	// Explain asks for statistics on how the search was executed.
+	Explain bool
+}
+
+// Record is a document found by Search.
+type Record struct {
+	Collection string                 `json:"collection"`
+	Resource   string                 `json:"resource"`
+	Document   map[string]interface{} `json:"document,omitempty"`
+}
+
+// SearchResult holds the records matched by Search, in the order requested
+// by its FindOptions.
+type SearchResult struct {
+	Records []Record
+	// NextCursor continues the search after Records when they are one
+	// page of a longer result; it is empty on the last page.
+	NextCursor string
+	// Explain is set when SearchOptions.Explain asked for it.
+	Explain *Explain
+}
+
+// Resources groups the names of the matching records by collection.
+func (r *SearchResult) Resources() map[string][]string {
+	names := make(map[string][]string)
+	for _, rec := range r.Records {
+		names[rec.Collection] = append(names[rec.Collection], rec.Resource)
+	}
+	return names
+}
+
+// Search returns the records that match query in the collections selected
+// by opts. No driver-wide lock is held while scanning: like Read, the scan
+// relies on writes replacing record files atomically.
+func (d *Driver) Search(query map[string]interface{}, opts *SearchOptions) (*SearchResult, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+
+	match, text, err := compileFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	found, err := newFinder(opts.FindOptions)
+	if err != nil {
+		return nil, err
+	}
+	ex := newExplainer(opts.Explain)
+
+	collections := opts.Collections
+	if len(collections) == 0 {
+		if collections, err = d.collections(); err != nil {
+			return nil, err
+		}
+	} else {
+		collections = sortedUnique(collections)
+	}
+
+	for _, collection := range collections {
+		start := ex.clock()
+		resources, index, err := d.planScan(collection, query, text)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		resources = found.remaining(collection, resources)
+		ex.spend(stagePlan, start)
+		ex.plan(collection, index, len(resources))
+
+		more := true
+		err = d.eachRecord(collection, resources, ex, func(resource string, record map[string]interface{}) bool {
+			start := ex.clock()
+			ok := match(record)
+			ex.spend(stageMatch, start)
+			if !ok {
+				return true
+			}
+			ex.matched()
+
+			start = ex.clock()
+			more = found.add(Record{Collection: collection, Resource: resource}, record, opts.Documents)
+			ex.spend(stageCollect, start)
+			return more
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+
+	start := ex.clock()
+	records, next := found.records()
+	ex.spend(stageCollect, start)
+	return &SearchResult{Records: records, NextCursor: next, Explain: ex.finish(len(records))}, nil
+}
+
+// eachRecord passes the documents of collection stored under resources to
+// fn in turn, until fn returns false. Resources deleted since they were
+// listed and expired documents are skipped, and every document gets its
+// "_id". Reading and decoding are charged to ex, which may be nil.
+func (d *Driver) eachRecord(collection string, resources []string, ex *explainer, fn func(resource string, doc map[string]interface{}) bool) error {
+	exp := d.expiry(collection)
+	for _, resource := range resources {
+		start := ex.clock()
+		b, err := ioutil.ReadFile(filepath.Join(d.dir, collection, resource+".json"))
+		if os.IsNotExist(err) {
+			// Deleted since the resources were listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ex.spend(stageFetch, start)
+		ex.examined()
+
+		start = ex.clock()
+		var doc map[string]interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		withID(doc, resource)
+		ex.spend(stageParse, start)
+		ex.parsed()
+
+		if exp.expired(doc) {
+			continue
+		}
+		if !fn(resource, doc) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// candidates returns the resources of collection that may match query
+// and text, the $text condition compileFilter took out of query: the
+// documents found by the text index when text is set, otherwise the
+// result of an index lookup when an index applies, otherwise every
+// resource in the collection.
+func (d *Driver) candidates(collection string, query map[string]interface{}, text *textQuery) ([]string, error) {
+	resources, _, err := d.planScan(collection, query, text)
+	return resources, err
+}
+
+// planScan is candidates that also names the index used, or returns ""
+// when the whole collection has to be scanned.
+func (d *Driver) planScan(collection string, query map[string]interface{}, text *textQuery) ([]string, string, error) {
+	if text != nil {
+		scores, index, err := d.textScores(collection, text)
+		if err != nil {
+			return nil, "", err
+		}
+		resources := make([]string, 0, len(scores))
+		for resource := range scores {
+			resources = append(resources, resource)
+		}
+		sort.Strings(resources)
+		return resources, index, nil
+	}
+
+	if cs := d.state(collection); cs != nil {
+		cs.mu.RLock()
+		idx, preds := cs.plan(query)
+		var resources []string
+		if idx != nil {
+			resources = idx.lookup(preds)
+		}
+		cs.mu.RUnlock()
+		if idx != nil {
+			return resources, idx.spec.Name, nil
+		}
+	}
+	resources, err := d.resources(collection)
+	return resources, "", err
+}
+
+// sortedUnique returns names sorted and without duplicates.
+func sortedUnique(names []string) []string {
+	out := append([]string(nil), names...)
+	sort.Strings(out)
+	n := 0
+	for i, name := range out {
+		if i == 0 || name != out[n-1] {
+			out[n] = name
+			n++
+		}
+	}
+	return out[:n]
+}
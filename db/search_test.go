@@ -0,0 +1,52 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchCollections(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, w := range []struct {
+		collection, resource string
+		n                    float64
+	}{
+		{"a", "1", 1}, {"a", "2", 2}, {"b", "1", 1}, {"c", "1", 1},
+	} {
+		if err := d.Write(w.collection, w.resource, map[string]interface{}{"n": w.n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := map[string]interface{}{"n": 1.0}
+	tests := []struct {
+		name string
+		opts *SearchOptions
+		want []Record
+	}{
+		{"every collection", nil, []Record{
+			{Collection: "a", Resource: "1"},
+			{Collection: "b", Resource: "1"},
+			{Collection: "c", Resource: "1"},
+		}},
+		{"selected collections", &SearchOptions{Collections: []string{"c", "a", "c"}}, []Record{
+			{Collection: "a", Resource: "1"},
+			{Collection: "c", Resource: "1"},
+		}},
+		{"missing collection", &SearchOptions{Collections: []string{"x", "b"}}, []Record{
+			{Collection: "b", Resource: "1"},
+		}},
+		{"documents", &SearchOptions{Collections: []string{"a"}, Documents: true}, []Record{
+			{Collection: "a", Resource: "1", Document: map[string]interface{}{"_id": "1", "n": 1.0}},
+		}},
+	}
+	for _, tt := range tests {
+		res, err := d.Search(query, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(res.Records, tt.want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, res.Records, tt.want)
+		}
+	}
+}
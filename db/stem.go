@@ -0,0 +1,204 @@
+package db
+
+import "strings"
+
+// stem reduces an English word to its stem with the Porter algorithm, so
+// that "connected", "connecting" and "connection" all become "connect".
+// Words that are not plain lowercase ASCII are returned unchanged.
+func stem(w string) string {
+	if len(w) <= 2 {
+		return w
+	}
+	for i := 0; i < len(w); i++ {
+		if w[i] < 'a' || w[i] > 'z' {
+			return w
+		}
+	}
+
+	w = stemStep1a(w)
+	w = stemStep1b(w)
+	w = stemStep1c(w)
+	w = applySuffixRules(w, stemStep2Rules)
+	w = applySuffixRules(w, stemStep3Rules)
+	w = stemStep4(w)
+	return stemStep5(w)
+}
+
+// isConsonant reports whether w[i] is a consonant. A y is a consonant at
+// the start of a word or after a vowel.
+func isConsonant(w string, i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !isConsonant(w, i-1)
+	}
+	return true
+}
+
+// measure returns m for a word of the form [C](VC){m}[V], where C and V
+// are runs of consonants and vowels.
+func measure(w string) int {
+	i, m := 0, 0
+	for i < len(w) && isConsonant(w, i) {
+		i++
+	}
+	for i < len(w) {
+		for i < len(w) && !isConsonant(w, i) {
+			i++
+		}
+		if i == len(w) {
+			break
+		}
+		for i < len(w) && isConsonant(w, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+func hasVowel(w string) bool {
+	for i := range w {
+		if !isConsonant(w, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// endsDoubleConsonant reports whether w ends with two equal consonants.
+func endsDoubleConsonant(w string) bool {
+	n := len(w)
+	return n >= 2 && w[n-1] == w[n-2] && isConsonant(w, n-1)
+}
+
+// endsCVC reports whether w ends consonant-vowel-consonant where the last
+// consonant is not w, x or y, as in "hop" or "fil".
+func endsCVC(w string) bool {
+	n := len(w)
+	if n < 3 || !isConsonant(w, n-1) || isConsonant(w, n-2) || !isConsonant(w, n-3) {
+		return false
+	}
+	return !strings.ContainsRune("wxy", rune(w[n-1]))
+}
+
+func stemStep1a(w string) string {
+	switch {
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "ies"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"):
+		return w
+	case strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+func stemStep1b(w string) string {
+	if strings.HasSuffix(w, "eed") {
+		if measure(w[:len(w)-3]) > 0 {
+			return w[:len(w)-1]
+		}
+		return w
+	}
+
+	var base string
+	switch {
+	case strings.HasSuffix(w, "ed"):
+		base = w[:len(w)-2]
+	case strings.HasSuffix(w, "ing"):
+		base = w[:len(w)-3]
+	default:
+		return w
+	}
+	if !hasVowel(base) {
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(base, "at"), strings.HasSuffix(base, "bl"), strings.HasSuffix(base, "iz"):
+		return base + "e"
+	case endsDoubleConsonant(base) && !strings.ContainsRune("lsz", rune(base[len(base)-1])):
+		return base[:len(base)-1]
+	case measure(base) == 1 && endsCVC(base):
+		return base + "e"
+	}
+	return base
+}
+
+func stemStep1c(w string) string {
+	if strings.HasSuffix(w, "y") && hasVowel(w[:len(w)-1]) {
+		return w[:len(w)-1] + "i"
+	}
+	return w
+}
+
+type suffixRule struct {
+	suffix, replacement string
+}
+
+var stemStep2Rules = []suffixRule{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"bli", "ble"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+	{"logi", "log"},
+}
+
+var stemStep3Rules = []suffixRule{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var stemStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// applySuffixRules replaces the suffix of the first rule that w ends with,
+// provided what precedes it has a positive measure. No shorter suffix is
+// tried once one matches.
+func applySuffixRules(w string, rules []suffixRule) string {
+	for _, r := range rules {
+		if strings.HasSuffix(w, r.suffix) {
+			base := w[:len(w)-len(r.suffix)]
+			if measure(base) > 0 {
+				return base + r.replacement
+			}
+			return w
+		}
+	}
+	return w
+}
+
+func stemStep4(w string) string {
+	for _, suffix := range stemStep4Suffixes {
+		if !strings.HasSuffix(w, suffix) {
+			continue
+		}
+		base := w[:len(w)-len(suffix)]
+		if suffix == "ion" && !strings.HasSuffix(base, "s") && !strings.HasSuffix(base, "t") {
+			return w
+		}
+		if measure(base) > 1 {
+			return base
+		}
+		return w
+	}
+	return w
+}
+
+func stemStep5(w string) string {
+	if strings.HasSuffix(w, "e") {
+		base := w[:len(w)-1]
+		if m := measure(base); m > 1 || (m == 1 && !endsCVC(base)) {
+			w = base
+		}
+	}
+	if strings.HasSuffix(w, "ll") && measure(w) > 1 {
+		w = w[:len(w)-1]
+	}
+	return w
+}
@@ -0,0 +1,409 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// stopWords are English words too common to be worth indexing.
+var stopWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		a about above after again against all am an and any are as at be
+		because been before being below between both but by can could did
+		do does doing don down during each few for from further had has
+		have having he her here hers herself him himself his how i if in
+		into is it its itself just me more most my myself no nor not now of
+		off on once only or other our ours ourselves out over own s same
+		she should so some such t than that the their theirs them
+		themselves then there these they this those through to too under
+		until up very was we were what when where which while who whom why
+		will with would you your yours yourself yourselves`) {
+		stopWords[w] = true
+	}
+}
+
+// analyze splits text into the terms a text index stores: runs of letters
+// and digits, lowercased, without stop words and stemmed.
+func analyze(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !stopWords[word] {
+			terms = append(terms, stem(word))
+		}
+	}
+	return terms
+}
+
+// textQuery is a parsed $search string. A document matches when it
+// contains any of the terms and none of the excluded ones.
+type textQuery struct {
+	terms    []string
+	excluded []string
+}
+
+// parseTextSearch parses a $search string: words are looked for with a
+// logical OR, and a word prefixed with "-" excludes the documents that
+// contain it.
+func parseTextSearch(search string) *textQuery {
+	seen := make(map[string]bool)
+	q := &textQuery{}
+	for _, word := range strings.Fields(search) {
+		negated := strings.HasPrefix(word, "-")
+		for _, term := range analyze(strings.TrimPrefix(word, "-")) {
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+			if negated {
+				q.excluded = append(q.excluded, term)
+			} else {
+				q.terms = append(q.terms, term)
+			}
+		}
+	}
+	return q
+}
+
+// compileFilter is compileQuery for queries that may contain a top-level
+// {"$text": {"$search": "..."}} condition. The $text condition is returned
+// apart, as it can only be answered by the text index of a collection.
+func compileFilter(query map[string]interface{}) (matcher, *textQuery, error) {
+	cond, ok := query["$text"]
+	if !ok {
+		m, err := compileQuery(query)
+		return m, nil, err
+	}
+
+	spec, ok := cond.(map[string]interface{})
+	if !ok {
+		return nil, nil, invalidQuery("$text expects an object")
+	}
+	var text *textQuery
+	for key, value := range spec {
+		switch key {
+		case "$search":
+			search, ok := value.(string)
+			if !ok {
+				return nil, nil, invalidQuery("$search expects a string")
+			}
+			text = parseTextSearch(search)
+		case "$language":
+			if value != "english" && value != "en" {
+				return nil, nil, invalidQuery("unsupported $text language %v", value)
+			}
+		default:
+			return nil, nil, invalidQuery("unknown $text option %s", key)
+		}
+	}
+	if text == nil {
+		return nil, nil, invalidQuery("$text requires $search")
+	}
+
+	rest := make(map[string]interface{}, len(query)-1)
+	for key, value := range query {
+		if key != "$text" {
+			rest[key] = value
+		}
+	}
+	m, err := compileQuery(rest)
+	return m, text, err
+}
+
+// BM25 parameters: bm25K1 limits how much repeating a term raises a
+// score, bm25B how much a long document is penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// textIndex is an inverted index of the terms found in the string fields
+// of a collection. It ranks documents with BM25.
+type textIndex struct {
+	spec     IndexSpec
+	docs     map[string]map[string]int // term frequencies per resource
+	postings map[string]map[string]int // term frequencies per term and resource
+	lengths  map[string]int            // number of terms per resource
+	total    int                       // number of terms in the collection
+	dirty    bool                      // changed since the last snapshot
+}
+
+func newTextIndex(spec IndexSpec) *textIndex {
+	return &textIndex{
+		spec:     spec,
+		docs:     make(map[string]map[string]int),
+		postings: make(map[string]map[string]int),
+		lengths:  make(map[string]int),
+	}
+}
+
+// terms counts the terms of the indexed fields of doc. Strings nested in
+// arrays are indexed too.
+func (t *textIndex) terms(doc map[string]interface{}) map[string]int {
+	freq := make(map[string]int)
+	var add func(v interface{})
+	add = func(v interface{}) {
+		switch x := v.(type) {
+		case string:
+			for _, term := range analyze(x) {
+				freq[term]++
+			}
+		case []interface{}:
+			for _, elem := range x {
+				add(elem)
+			}
+		}
+	}
+	for _, field := range t.spec.Fields {
+		for _, v := range fieldValues(doc, field) {
+			add(v)
+		}
+	}
+	return freq
+}
+
+// insert (re)indexes resource under the terms of doc.
+func (t *textIndex) insert(resource string, doc map[string]interface{}) {
+	t.remove(resource)
+	t.add(resource, t.terms(doc))
+}
+
+func (t *textIndex) add(resource string, freq map[string]int) {
+	if len(freq) == 0 {
+		return
+	}
+	t.docs[resource] = freq
+	for term, n := range freq {
+		if t.postings[term] == nil {
+			t.postings[term] = make(map[string]int)
+		}
+		t.postings[term][resource] = n
+		t.lengths[resource] += n
+	}
+	t.total += t.lengths[resource]
+	t.dirty = true
+}
+
+// remove drops resource from the index.
+func (t *textIndex) remove(resource string) {
+	freq, ok := t.docs[resource]
+	if !ok {
+		return
+	}
+	for term := range freq {
+		delete(t.postings[term], resource)
+		if len(t.postings[term]) == 0 {
+			delete(t.postings, term)
+		}
+	}
+	t.total -= t.lengths[resource]
+	delete(t.lengths, resource)
+	delete(t.docs, resource)
+	t.dirty = true
+}
+
+func (t *textIndex) clear() {
+	t.docs = make(map[string]map[string]int)
+	t.postings = make(map[string]map[string]int)
+	t.lengths = make(map[string]int)
+	t.total = 0
+	t.dirty = true
+}
+
+// scores returns the BM25 score of every resource that matches q.
+func (t *textIndex) scores(q *textQuery) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(t.docs) == 0 {
+		return scores
+	}
+
+	n := float64(len(t.docs))
+	avgLength := float64(t.total) / n
+	for _, term := range q.terms {
+		posting := t.postings[term]
+		idf := math.Log(1 + (n-float64(len(posting))+0.5)/(float64(len(posting))+0.5))
+		for resource, tf := range posting {
+			f := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(t.lengths[resource])/avgLength
+			scores[resource] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+	for _, term := range q.excluded {
+		for resource := range t.postings[term] {
+			delete(scores, resource)
+		}
+	}
+	return scores
+}
+
+// textFormat is the version of the text index snapshot format. Snapshots
+// of another version, for example made with another stemmer, are rebuilt
+// on load.
+const textFormat = 1
+
+// textSnapshot is the on-disk form of a text index.
+type textSnapshot struct {
+	Version int                       `json:"version"`
+	Fields  []string                  `json:"fields"`
+	Docs    map[string]map[string]int `json:"docs"`
+}
+
+// saveTextIndex writes a snapshot of t, like saveIndex.
+func (d *Driver) saveTextIndex(collection string, t *textIndex) error {
+	b, err := json.Marshal(textSnapshot{Version: textFormat, Fields: t.spec.Fields, Docs: t.docs})
+	if err != nil {
+		return err
+	}
+	if err := d.writeFileAtomic(d.indexPath(collection, t.spec.Name), b); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// loadTextIndex restores a text index from its snapshot, or rebuilds it
+// from the collection if the snapshot is missing or does not match spec.
+func (d *Driver) loadTextIndex(collection string, spec IndexSpec) (*textIndex, error) {
+	t := newTextIndex(spec)
+
+	var snap textSnapshot
+	b, err := ioutil.ReadFile(d.indexPath(collection, spec.Name))
+	if err == nil && json.Unmarshal(b, &snap) == nil &&
+		snap.Version == textFormat && snap.Docs != nil && equalFields(snap.Fields, spec.Fields) {
+		for resource, freq := range snap.Docs {
+			t.add(resource, freq)
+		}
+		t.dirty = false
+		return t, nil
+	}
+
+	d.log.Info("Rebuilding text index %s on %s", spec.Name, collection)
+	if err := d.buildTextIndex(collection, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// buildTextIndex indexes every document currently stored in collection.
+func (d *Driver) buildTextIndex(collection string, t *textIndex) error {
+	resources, err := d.resources(collection)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		b, err := d.readStored(collection, resource)
+		if err != nil {
+			return err
+		}
+		t.insert(resource, withID(decodeObject(b), resource))
+	}
+	return nil
+}
+
+// textScores scores the resources of collection that match text with the
+// text index of the collection, which it also names.
+func (d *Driver) textScores(collection string, text *textQuery) (map[string]float64, string, error) {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil, "", invalidQuery("$text requires a text index on %s", collection)
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.text == nil {
+		return nil, "", invalidQuery("$text requires a text index on %s", collection)
+	}
+	return cs.text.scores(text), cs.text.spec.Name, nil
+}
+
+// TextSearchOptions filters and shapes the results of TextSearch.
+type TextSearchOptions struct {
+	// Filter is a query the documents must match as well.
+	Filter map[string]interface{}
+	// Projection, Skip and Limit work as in FindOptions. Results are
+	// always ranked by score, so there is no Sort or Cursor.
+	Projection map[string]bool
+	Skip       int
+	Limit      int
+	// ScoreField, when set, adds the relevance score of every document to
+	// it under this field.
+	ScoreField string
+}
+
+// TextSearch returns the documents of collection that match search, best
+// first. search is parsed like the $search of a $text query and matched
+// against the text index of the collection; documents are ranked by their
+// BM25 score, and ties by resource name.
+func (d *Driver) TextSearch(collection, search string, opts *TextSearchOptions) ([]Record, error) {
+	if opts == nil {
+		opts = &TextSearchOptions{}
+	}
+
+	if collection == "" {
+		return nil, fmt.Errorf("Missing collection - unable to search")
+	}
+	if _, ok := opts.Filter["$text"]; ok {
+		return nil, invalidQuery("the filter of a text search cannot contain $text")
+	}
+	find := FindOptions{Projection: opts.Projection, Skip: opts.Skip, Limit: opts.Limit}
+	if err := find.validate(); err != nil {
+		return nil, err
+	}
+	match, err := compileQuery(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	scores, _, err := d.textScores(collection, parseTextSearch(search))
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]string, 0, len(scores))
+	for resource := range scores {
+		ranked = append(ranked, resource)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if scores[a] != scores[b] {
+			return scores[a] > scores[b]
+		}
+		return a < b
+	})
+
+	var records []Record
+	skip := opts.Skip
+	err = d.eachRecord(collection, ranked, nil, func(resource string, doc map[string]interface{}) bool {
+		if !match(doc) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+
+		doc = project(doc, opts.Projection)
+		if opts.ScoreField != "" {
+			doc[opts.ScoreField] = scores[resource]
+		}
+		records = append(records, Record{Collection: collection, Resource: resource, Document: doc})
+		return opts.Limit <= 0 || len(records) < opts.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
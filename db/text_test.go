@@ -0,0 +1,243 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	// Pairs from the Porter algorithm's published examples.
+	tests := []struct {
+		word, want string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"ties", "ti"},
+		{"cats", "cat"},
+		{"feed", "feed"},
+		{"agreed", "agre"},
+		{"plastered", "plaster"},
+		{"motoring", "motor"},
+		{"sing", "sing"},
+		{"conflated", "conflat"},
+		{"troubled", "troubl"},
+		{"sized", "size"},
+		{"hopping", "hop"},
+		{"falling", "fall"},
+		{"hissing", "hiss"},
+		{"filing", "file"},
+		{"happy", "happi"},
+		{"sky", "sky"},
+		{"relational", "relat"},
+		{"conditional", "condit"},
+		{"rational", "ration"},
+		{"digitizer", "digit"},
+		{"operator", "oper"},
+		{"triplicate", "triplic"},
+		{"formative", "form"},
+		{"electrical", "electr"},
+		{"hopeful", "hope"},
+		{"goodness", "good"},
+		{"allowance", "allow"},
+		{"adjustable", "adjust"},
+		{"adoption", "adopt"},
+		{"controll", "control"},
+		{"generalization", "gener"},
+		{"running", "run"},
+		{"runs", "run"},
+		{"is", "is"},
+		{"café", "café"},
+	}
+	for _, tt := range tests {
+		if got := stem(tt.word); got != tt.want {
+			t.Errorf("stem(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"The Running dogs, and their OWNERS!", []string{"run", "dog", "owner"}},
+		{"e-mail 42 times", []string{"e", "mail", "42", "time"}},
+	}
+	for _, tt := range tests {
+		if got := analyze(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("analyze(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTextScores(t *testing.T) {
+	idx := newTextIndex(IndexSpec{Name: "body_text", Fields: []string{"body"}, Text: true})
+	for resource, body := range map[string]string{
+		"short":   "coffee",
+		"long":    "coffee beans roasted dark slowly",
+		"twice":   "coffee coffee beans roasted",
+		"decaf":   "decaf coffee",
+		"nothing": "tea",
+	} {
+		idx.insert(resource, map[string]interface{}{"body": body})
+	}
+
+	scores := idx.scores(parseTextSearch("coffee -decaf"))
+	if len(scores) != 3 {
+		t.Fatalf("scores %v, want short, long and twice", scores)
+	}
+	if !(scores["short"] > scores["twice"] && scores["twice"] > scores["long"]) {
+		t.Errorf("scores %v: want short > twice > long", scores)
+	}
+
+	// With 5 documents of 13 terms, "tea" is in one document of average
+	// length: idf = ln(1 + 4.5/1.5), tf = 1, norm = 1.
+	got := idx.scores(parseTextSearch("tea"))["nothing"]
+	norm := 1 - bm25B + bm25B*1/(13.0/5)
+	want := math.Log(1+4.5/1.5) * (bm25K1 + 1) / (1 + bm25K1*norm)
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("score of tea = %v, want %v", got, want)
+	}
+
+	idx.remove("nothing")
+	if scores := idx.scores(parseTextSearch("tea")); len(scores) != 0 {
+		t.Errorf("scores after remove: %v", scores)
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for resource, doc := range map[string]string{
+		"p1": `{"title": "Running shoes", "body": "Light shoes for running", "price": 80}`,
+		"p2": `{"title": "Trail runs", "body": "Shoes that grip", "price": 120}`,
+		"p3": `{"title": "Coffee", "body": "Beans to run on", "tags": ["decaf"], "price": 5}`,
+		"p4": `{"title": "Tea", "body": "Leaves", "price": 4}`,
+	} {
+		if err := d.Write("posts", resource, json.RawMessage(doc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Search(decode(t, `{"$text": {"$search": "run"}}`), nil); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("$text without a text index: %v, want ErrInvalidQuery", err)
+	}
+	if _, err := d.CreateIndex("posts", "title,body,tags", &IndexOptions{Text: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		search string
+		opts   TextSearchOptions
+		want   []string
+	}{
+		{"stemmed", "run", TextSearchOptions{}, []string{"p1", "p2", "p3"}},
+		// "tea" is rarer than "shoes", so it weighs more.
+		{"or", "shoes tea", TextSearchOptions{}, []string{"p4", "p1", "p2"}},
+		{"excluded", "run -decaf", TextSearchOptions{}, []string{"p1", "p2"}},
+		{"array field", "decaf", TextSearchOptions{}, []string{"p3"}},
+		{"stop words only", "the and", TextSearchOptions{}, nil},
+		{"filter", "run", TextSearchOptions{Filter: decode(t, `{"price": {"$lt": 100}}`)}, []string{"p1", "p3"}},
+		{"skip and limit", "run", TextSearchOptions{Skip: 1, Limit: 1}, []string{"p2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := d.TextSearch("posts", tt.search, &tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range records {
+				got = append(got, r.Resource)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%q: %v, want %v", tt.search, got, tt.want)
+			}
+		})
+	}
+
+	// p1 has "run" in both fields, so it ranks first; the score is added
+	// under ScoreField and survives the projection.
+	records, err := d.TextSearch("posts", "run", &TextSearchOptions{
+		Projection: map[string]bool{"title": true},
+		ScoreField: "score",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Resource != "p1" {
+		t.Fatalf("records %+v", records)
+	}
+	for i, r := range records {
+		score, ok := r.Document["score"].(float64)
+		if !ok || score <= 0 || r.Document["body"] != nil {
+			t.Errorf("record %d: %v", i, r.Document)
+		}
+		if i > 0 && score > records[i-1].Document["score"].(float64) {
+			t.Errorf("record %d scores above the one before it", i)
+		}
+	}
+
+	// $text in Search and Count uses the same index and combines with the
+	// rest of the query.
+	res, err := d.Search(decode(t, `{"$text": {"$search": "shoes"}, "price": {"$gt": 100}}`), &SearchOptions{Explain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Resources()["posts"]; !reflect.DeepEqual(got, []string{"p2"}) {
+		t.Errorf("$text search: %v, want [p2]", got)
+	}
+	if plan := res.Explain.Collections[0]; plan.Plan != "IXSCAN" || plan.Index != "title_body_tags_text" || plan.Candidates != 2 {
+		t.Errorf("$text plan %+v", plan)
+	}
+	if n, err := d.Count("posts", decode(t, `{"$text": {"$search": "run -shoes"}}`)); err != nil || n != 1 {
+		t.Errorf("$text count: %d, %v, want 1", n, err)
+	}
+	for _, q := range []string{
+		`{"$text": "run"}`,
+		`{"$text": {}}`,
+		`{"$text": {"$search": 1}}`,
+		`{"$text": {"$search": "run", "$language": "fr"}}`,
+		`{"$text": {"$search": "run", "$caseSensitive": true}}`,
+	} {
+		if _, err := d.Search(decode(t, q), nil); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: %v, want ErrInvalidQuery", q, err)
+		}
+	}
+
+	// Writes and deletes update the index, which survives a restart.
+	if err := d.Write("posts", "p4", json.RawMessage(`{"title": "Running tea"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("posts", "p2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	records, err = d.TextSearch("posts", "run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Resource)
+	}
+	sort.Strings(got)
+	if want := []string{"p1", "p3", "p4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after restart: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// expiresField holds the expiry time that WriteOptions.ExpiresAt gives a
+// document.
+const expiresField = "_expires"
+
+// defaultReapInterval is how often the reaper runs unless
+// Options.ReapInterval says otherwise; MongoDB's TTL monitor also runs
+// once a minute.
+const defaultReapInterval = time.Minute
+
+// TTLSpec makes the documents of a collection expire a fixed time after
+// the date stored in one of their fields, like a MongoDB TTL index.
+type TTLSpec struct {
+	Field string `json:"field"`
+	// ExpireAfterSeconds is how long after the date a document expires.
+	// With zero, the field holds the expiry time itself.
+	ExpireAfterSeconds int64 `json:"expireAfterSeconds"`
+}
+
+// SetTTL sets the TTL of collection, replacing any previous one; a nil
+// ttl removes it. Dates are RFC 3339 strings, as time.Time values are
+// stored, or {"$date": ...} values. When the field holds an array, its
+// earliest date counts; documents without a date in the field never
+// expire through the TTL.
+func (d *Driver) SetTTL(collection string, ttl *TTLSpec) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - unable to set TTL!")
+	}
+	if ttl != nil {
+		if ttl.Field == "" {
+			return fmt.Errorf("Missing field - unable to set TTL on %s!", collection)
+		}
+		if ttl.ExpireAfterSeconds < 0 {
+			return fmt.Errorf("Invalid TTL on %s: expireAfterSeconds must not be negative", collection)
+		}
+		ttl = &TTLSpec{Field: ttl.Field, ExpireAfterSeconds: ttl.ExpireAfterSeconds}
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cs := d.getOrCreateState(collection)
+	meta := cs.meta
+	meta.TTL = ttl
+	if err := d.saveMeta(collection, meta); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	cs.meta = meta
+	cs.mu.Unlock()
+	return nil
+}
+
+// TTL returns the TTL of collection, or nil if it has none.
+func (d *Driver) TTL(collection string) *TTLSpec {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.meta.TTL == nil {
+		return nil
+	}
+	ttl := *cs.meta.TTL
+	return &ttl
+}
+
+// withExpiry returns v encoded with its expiry time set to at, and marks
+// collection as holding documents that expire so that reads check for
+// it. The caller must hold the collection mutex.
+func (d *Driver) withExpiry(collection string, v interface{}, at time.Time) (json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := objectFields(b); !ok {
+		return nil, fmt.Errorf("Only a JSON object can expire - unable to save record in %s!", collection)
+	}
+	expires, _ := json.Marshal(at.UTC().Format(time.RFC3339Nano))
+	if b, _, err = setFields(b, []rawField{{expiresField, expires}}); err != nil {
+		return nil, err
+	}
+
+	cs := d.getOrCreateState(collection)
+	cs.mu.RLock()
+	marked := cs.meta.Expiring
+	cs.mu.RUnlock()
+	if !marked {
+		meta := cs.meta
+		meta.Expiring = true
+		if err := d.saveMeta(collection, meta); err != nil {
+			return nil, err
+		}
+		cs.mu.Lock()
+		cs.meta = meta
+		cs.mu.Unlock()
+	}
+	return b, nil
+}
+
+// expiry tells which documents of a collection have expired at a given
+// time. A nil expiry, for a collection whose documents cannot expire,
+// reports none.
+type expiry struct {
+	ttl *TTLSpec
+	now time.Time
+}
+
+// expiry returns the expiry rules of collection as of now, or nil when
+// none of its documents can expire.
+func (d *Driver) expiry(collection string) *expiry {
+	cs := d.state(collection)
+	if cs == nil {
+		return nil
+	}
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.meta.TTL == nil && !cs.meta.Expiring {
+		return nil
+	}
+	return &expiry{ttl: cs.meta.TTL, now: time.Now()}
+}
+
+func (e *expiry) expired(doc map[string]interface{}) bool {
+	if e == nil {
+		return false
+	}
+	if at, ok := storedDate(doc[expiresField]); ok && !at.After(e.now) {
+		return true
+	}
+	if e.ttl == nil {
+		return false
+	}
+
+	after := time.Duration(e.ttl.ExpireAfterSeconds) * time.Second
+	for _, v := range fieldValues(doc, e.ttl.Field) {
+		items := []interface{}{v}
+		if arr, ok := v.([]interface{}); ok {
+			items = arr
+		}
+		for _, item := range items {
+			if t, ok := storedDate(item); ok && !t.Add(after).After(e.now) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expiredRaw is expired for a stored record.
+func (e *expiry) expiredRaw(b []byte) bool {
+	return e != nil && e.expired(decodeObject(b))
+}
+
+// storedDate reads a date as documents store it: an RFC 3339 string or a
+// {"$date": ...} value.
+func storedDate(v interface{}) (time.Time, bool) {
+	if s, ok := v.(string); ok {
+		t, err := parseDate(s)
+		return t, err == nil
+	}
+	return dateValue(v)
+}
+
+// startReaper starts the goroutine that deletes expired documents every
+// interval, until Close stops it.
+func (d *Driver) startReaper(interval time.Duration) {
+	d.reaperStop = make(chan struct{})
+	d.reaperDone = make(chan struct{})
+	go func() {
+		defer close(d.reaperDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.reaperStop:
+				return
+			case <-ticker.C:
+				if n, err := d.reapExpired(); err != nil {
+					d.log.Error("Deleting expired documents failed: %s", err)
+				} else if n > 0 {
+					d.log.Debug("Deleted %d expired documents", n)
+				}
+			}
+		}
+	}()
+}
+
+// stopReaper stops the reaper and waits for a running pass to finish.
+func (d *Driver) stopReaper() {
+	if d.reaperStop == nil {
+		return
+	}
+	close(d.reaperStop)
+	<-d.reaperDone
+	d.reaperStop = nil
+}
+
+// reapExpired deletes the expired documents of every collection whose
+// documents can expire and returns how many it deleted.
+func (d *Driver) reapExpired() (int, error) {
+	d.catalogMu.Lock()
+	collections := make([]string, 0, len(d.colls))
+	for name := range d.colls {
+		collections = append(collections, name)
+	}
+	d.catalogMu.Unlock()
+	sort.Strings(collections)
+
+	total := 0
+	for _, collection := range collections {
+		n, err := d.reapCollection(collection)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// reapCollection deletes the expired documents of collection. They are
+// found without holding the collection mutex and checked again under it,
+// as they may have been rewritten in between.
+func (d *Driver) reapCollection(collection string) (int, error) {
+	e := d.expiry(collection)
+	if e == nil {
+		return 0, nil
+	}
+
+	resources, err := d.resources(collection)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for _, resource := range resources {
+		b, err := d.readStored(collection, resource)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if e.expiredRaw(b) {
+			expired = append(expired, resource)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var ops []walOp
+	for _, resource := range expired {
+		b, err := d.readStored(collection, resource)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if e.expiredRaw(b) {
+			ops = append(ops, walOp{Kind: opDelete, Collection: collection, Resource: resource})
+		}
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	return len(ops), d.commit(ops)
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-2 * time.Hour).Format(time.RFC3339)
+	future := now.Add(2 * time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name string
+		ttl  *TTLSpec
+		doc  string
+		want bool
+	}{
+		{"no expiry", nil, `{"a": 1}`, false},
+		{"_expires passed", nil, `{"_expires": "` + past + `"}`, true},
+		{"_expires ahead", nil, `{"_expires": "` + future + `"}`, false},
+		{"_expires not a date", nil, `{"_expires": "soon"}`, false},
+		{"ttl field expires itself", &TTLSpec{Field: "at"}, `{"at": "` + past + `"}`, true},
+		{"ttl field ahead", &TTLSpec{Field: "at"}, `{"at": "` + future + `"}`, false},
+		{"ttl after seconds", &TTLSpec{Field: "at", ExpireAfterSeconds: 3600}, `{"at": "` + past + `"}`, true},
+		{"ttl not after enough seconds", &TTLSpec{Field: "at", ExpireAfterSeconds: 3 * 3600}, `{"at": "` + past + `"}`, false},
+		{"ttl $date", &TTLSpec{Field: "at"}, `{"at": {"$date": "` + past + `"}}`, true},
+		{"ttl earliest of an array", &TTLSpec{Field: "at"}, `{"at": ["` + future + `", "` + past + `"]}`, true},
+		{"ttl nested field", &TTLSpec{Field: "s.at"}, `{"s": {"at": "` + past + `"}}`, true},
+		{"ttl missing field", &TTLSpec{Field: "at"}, `{"b": "` + past + `"}`, false},
+		{"ttl number", &TTLSpec{Field: "at"}, `{"at": 0}`, false},
+		{"_expires besides a ttl", &TTLSpec{Field: "at"}, `{"_expires": "` + past + `", "at": "` + future + `"}`, true},
+	}
+	for _, tt := range tests {
+		e := &expiry{ttl: tt.ttl, now: now}
+		if got := e.expired(decode(t, tt.doc)); got != tt.want {
+			t.Errorf("%s: expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if (*expiry)(nil).expired(decode(t, `{"_expires": "`+past+`"}`)) {
+		t.Error("a nil expiry expired a document")
+	}
+}
+
+func TestExpiredDocumentsAreHidden(t *testing.T) {
+	d := newTestDriver(t, &Options{ReapInterval: -1})
+	hour := time.Hour
+	for _, w := range []struct {
+		resource string
+		in       time.Duration
+	}{
+		{"gone", -hour},
+		{"live", hour},
+		{"plain", 0},
+	} {
+		var opts *WriteOptions
+		if w.in != 0 {
+			opts = &WriteOptions{ExpiresAt: time.Now().Add(w.in)}
+		}
+		if err := d.WriteWith("c", w.resource, map[string]interface{}{"n": 1}, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("c", "gone", &doc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read of an expired document: %v, want ErrNotFound", err)
+	}
+	if ok, err := d.Exists("c", "gone"); ok || err != nil {
+		t.Errorf("Exists of an expired document: %v, %v", ok, err)
+	}
+	if err := d.WriteWith("c", "gone", map[string]interface{}{"n": 2}, &WriteOptions{Mode: InsertOnly}); err != nil {
+		t.Errorf("inserting over an expired document: %v", err)
+	}
+	if err := d.WriteWith("c", "gone", map[string]interface{}{"n": 1}, &WriteOptions{ExpiresAt: time.Now().Add(-hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"live", "plain"}
+	res, err := d.Search(decode(t, `{"n": 1}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Resources()["c"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search: %v, want %v", got, want)
+	}
+	records, _, err := d.ReadAllWith("c", nil)
+	if err != nil || len(records) != 2 {
+		t.Errorf("ReadAllWith: %d records, %v, want 2", len(records), err)
+	}
+	var names []string
+	if err := d.Iterate("c", func(resource string, _ json.RawMessage) error {
+		names = append(names, resource)
+		return nil
+	}); err != nil || len(names) != 2 {
+		t.Errorf("Iterate: %v, %v, want 2 records", names, err)
+	}
+	for _, q := range []string{`{}`, `{"n": 1}`} {
+		if n, err := d.Count("c", decode(t, q)); n != 2 || err != nil {
+			t.Errorf("Count(%s) = %d, %v, want 2", q, n, err)
+		}
+	}
+
+	n, err := d.reapExpired()
+	if err != nil || n != 1 {
+		t.Fatalf("reapExpired = %d, %v, want 1", n, err)
+	}
+	if _, err := d.readStored("c", "gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired document not deleted: %v", err)
+	}
+}
+
+func TestCollectionTTL(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, &Options{ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for resource, seen := range map[string]time.Time{
+		"old": time.Now().Add(-2 * time.Hour),
+		"new": time.Now(),
+	} {
+		if err := d.Write("sessions", resource, map[string]interface{}{"seen": seen}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ttl := range []*TTLSpec{{}, {Field: "seen", ExpireAfterSeconds: -1}} {
+		if err := d.SetTTL("sessions", ttl); err == nil {
+			t.Errorf("SetTTL(%+v) succeeded", ttl)
+		}
+	}
+	if err := d.SetTTL("sessions", &TTLSpec{Field: "seen", ExpireAfterSeconds: 3600}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The TTL is kept with the collection across a restart.
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = New(dir, &Options{ReapInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if ttl := d.TTL("sessions"); ttl == nil || *ttl != (TTLSpec{Field: "seen", ExpireAfterSeconds: 3600}) {
+		t.Fatalf("TTL after a restart: %+v", ttl)
+	}
+	if n, err := d.Count("sessions", nil); n != 1 || err != nil {
+		t.Errorf("Count = %d, %v, want 1", n, err)
+	}
+
+	if err := d.SetTTL("sessions", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := d.TTL("sessions"); ttl != nil {
+		t.Errorf("TTL after removing it: %+v", ttl)
+	}
+	if n, err := d.Count("sessions", nil); n != 2 || err != nil {
+		t.Errorf("Count without a TTL = %d, %v, want 2", n, err)
+	}
+}
+
+func TestReaper(t *testing.T) {
+	d := newTestDriver(t, &Options{ReapInterval: 10 * time.Millisecond})
+	if err := d.WriteWith("c", "r", map[string]interface{}{"n": 1}, &WriteOptions{ExpiresAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := d.readStored("c", "r")
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the reaper did not delete the expired document: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExpiringWriteKeepsNumbers(t *testing.T) {
+	d := newTestDriver(t, &Options{ReapInterval: -1})
+
+	opts := &WriteOptions{ExpiresAt: time.Now().Add(time.Hour)}
+	if err := d.WriteWith("c", "r", json.RawMessage(`{"big": 9007199254740993}`), opts); err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.readStored("c", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "9007199254740993") || !strings.Contains(string(b), expiresField) {
+		t.Fatalf("stored record lost a value:\n%s", b)
+	}
+	if err := d.WriteWith("c", "r", []int{1}, opts); err == nil {
+		t.Error("an array was given an expiry")
+	}
+}
+
+func TestExpiredDocumentsReleaseUniqueKeys(t *testing.T) {
+	d := newTestDriver(t, &Options{ReapInterval: -1})
+
+	if _, err := d.CreateIndex("users", "email", &IndexOptions{Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	user := map[string]interface{}{"email": "a@example.com"}
+	expired := &WriteOptions{ExpiresAt: time.Now().Add(-time.Second)}
+	if err := d.WriteWith("users", "old", user, expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("users", "new", user); err != nil {
+		t.Fatalf("writing the key of an expired document: %v", err)
+	}
+
+	// A rebuilt index must not see the two as duplicates either.
+	if err := d.DropIndex("users", "email"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateIndex("users", "email", &IndexOptions{Unique: true}); err != nil {
+		t.Fatalf("rebuilding the index: %v", err)
+	}
+	if err := d.Write("users", "other", user); err == nil {
+		t.Fatal("a live document's unique key was not enforced")
+	}
+}
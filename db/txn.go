@@ -0,0 +1,175 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Tx stages writes and deletes across any number of collections. Nothing
+// touches the disk until the function passed to Driver.Txn returns nil.
+type Tx struct {
+	d   *Driver
+	ops []walOp
+	// reads holds the revision of every resource read from disk, "" for
+	// missing ones, so that the commit can tell whether it changed since.
+	reads map[txnRead]string
+}
+
+// txnRead names a resource read by a transaction.
+type txnRead struct {
+	collection, resource string
+}
+
+// Write stages v to be stored as collection/resource.
+func (tx *Tx) Write(collection, resource string, v interface{}) error {
+	if collection == "" {
+		return fmt.Errorf("Missing collection - no place to save record!")
+	}
+
+	if resource == "" {
+		return fmt.Errorf("Missing resource - unable to save record (no name)!")
+	}
+
+	b, err := encode(v)
+	if err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, walOp{Kind: opWrite, Collection: collection, Resource: resource, Data: b})
+	return nil
+}
+
+// Delete stages the removal of collection/resource. The commit fails with
+// ErrNotFound if the resource does not exist at that point.
+func (tx *Tx) Delete(collection, resource string) error {
+	if collection == "" || resource == "" {
+		return fmt.Errorf("Missing collection or resource - unable to delete!")
+	}
+
+	tx.ops = append(tx.ops, walOp{Kind: opDelete, Collection: collection, Resource: resource})
+	return nil
+}
+
+// Read decodes collection/resource into v as the transaction currently
+// sees it: staged writes and deletes win over what is stored on disk. If
+// the stored resource changes before the transaction commits, the commit
+// fails with ErrRevisionMismatch, so a read-modify-write cannot lose a
+// concurrent write.
+func (tx *Tx) Read(collection, resource string, v interface{}) error {
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		op := tx.ops[i]
+		if op.Collection != collection || op.Resource != resource {
+			continue
+		}
+		if op.Kind == opDelete {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, collection, resource)
+		}
+		return json.Unmarshal(rawWithID(op.Data, resource), v)
+	}
+
+	rev, err := tx.d.ReadRev(collection, resource, v)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	key := txnRead{collection, resource}
+	if _, ok := tx.reads[key]; !ok {
+		tx.reads[key] = rev
+	}
+	return err
+}
+
+// Txn runs fn and commits the operations it staged all-or-nothing. The
+// collections involved are locked in sorted order so that concurrent
+// transactions cannot deadlock. The operations are logged as a single
+// write-ahead log record before any of them is applied; if the process
+// dies half way through, New replays the record on the next start.
+func (d *Driver) Txn(fn func(tx *Tx) error) error {
+	tx := &Tx{d: d, reads: make(map[txnRead]string)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	collections := make([]string, 0, len(tx.ops)+len(tx.reads))
+	for _, op := range tx.ops {
+		collections = append(collections, op.Collection)
+	}
+	for key := range tx.reads {
+		collections = append(collections, key.collection)
+	}
+	unlock := d.lockCollections(collections)
+	defer unlock()
+
+	if err := d.checkTxn(tx.ops, tx.reads); err != nil {
+		return err
+	}
+
+	return d.commit(tx.ops)
+}
+
+// lockCollections locks the mutex of every named collection in sorted
+// order and returns a function that releases them.
+func (d *Driver) lockCollections(collections []string) func() {
+	names := append([]string(nil), collections...)
+	sort.Strings(names)
+
+	var locked []*sync.Mutex
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		m := d.getOrCreateMutex(name)
+		m.Lock()
+		locked = append(locked, m)
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].Unlock()
+		}
+	}
+}
+
+// checkTxn makes sure that the resources in reads are still at the
+// revisions the transaction read and that every staged delete targets a
+// resource that exists, either on disk or because an earlier operation
+// wrote it. The caller must hold the collection mutexes.
+func (d *Driver) checkTxn(ops []walOp, reads map[txnRead]string) error {
+	for key, rev := range reads {
+		current, err := d.Rev(key.collection, key.resource)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if current != rev {
+			return fmt.Errorf("%w: %s/%s changed during the transaction",
+				ErrRevisionMismatch, key.collection, key.resource)
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, op := range ops {
+		key := op.Collection + "/" + op.Resource
+		if op.Kind == opWrite {
+			present[key] = true
+			continue
+		}
+
+		exists, known := present[key]
+		if !known {
+			var err error
+			if exists, err = d.exists(op.Collection, op.Resource); err != nil {
+				return err
+			}
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		present[key] = false
+	}
+	return nil
+}
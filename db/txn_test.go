@@ -0,0 +1,204 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestTxnRecoversHalfAppliedCommit(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("accounts", "a", map[string]interface{}{"balance": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process dies after logging the transaction and applying only
+	// its first operation.
+	ops := []walOp{
+		writeOp(t, "accounts", "a", map[string]interface{}{"balance": 5}),
+		writeOp(t, "accounts", "b", map[string]interface{}{"balance": 5}),
+		writeOp(t, "transfers", "t1", map[string]interface{}{"amount": 5}),
+	}
+	logOnly(t, d, ops...)
+	if err := d.applyOps(ops[:1]); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, d)
+
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for _, want := range []struct {
+		collection, resource, field string
+		value                       float64
+	}{
+		{"accounts", "a", "balance", 5},
+		{"accounts", "b", "balance", 5},
+		{"transfers", "t1", "amount", 5},
+	} {
+		var doc map[string]interface{}
+		if err := d.Read(want.collection, want.resource, &doc); err != nil {
+			t.Fatalf("%s/%s: %v", want.collection, want.resource, err)
+		}
+		if doc[want.field] != want.value {
+			t.Errorf("%s/%s: %s = %v, want %v", want.collection, want.resource, want.field, doc[want.field], want.value)
+		}
+	}
+}
+
+func TestTxnFailureWritesNothing(t *testing.T) {
+	d := newTestDriver(t, nil)
+
+	err := d.Txn(func(tx *Tx) error {
+		if err := tx.Write("accounts", "a", map[string]interface{}{"balance": 10}); err != nil {
+			return err
+		}
+		return tx.Delete("accounts", "missing")
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Txn: err = %v, want ErrNotFound", err)
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("accounts", "a", &doc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("accounts/a: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTxnReadSeesStagedOps(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("c", "r", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Txn(func(tx *Tx) error {
+		var doc map[string]interface{}
+		if err := tx.Write("c", "r", map[string]interface{}{"n": 2}); err != nil {
+			return err
+		}
+		if err := tx.Read("c", "r", &doc); err != nil || doc["n"] != 2.0 {
+			return fmt.Errorf("read after write: %v, %v", doc, err)
+		}
+		if err := tx.Delete("c", "r"); err != nil {
+			return err
+		}
+		if err := tx.Read("c", "r", &doc); !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("read after delete: err = %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnFailsWhenReadDocumentChanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(d *Driver) error
+		race  func(d *Driver) error
+	}{
+		{
+			name:  "write",
+			setup: func(d *Driver) error { return d.Write("accounts", "a", map[string]interface{}{"balance": 100}) },
+			race:  func(d *Driver) error { return d.Write("accounts", "a", map[string]interface{}{"balance": 0}) },
+		},
+		{
+			name:  "delete",
+			setup: func(d *Driver) error { return d.Write("accounts", "a", map[string]interface{}{"balance": 100}) },
+			race:  func(d *Driver) error { return d.Delete("accounts", "a") },
+		},
+		{
+			name:  "create",
+			setup: func(d *Driver) error { return nil },
+			race:  func(d *Driver) error { return d.Write("accounts", "a", map[string]interface{}{"balance": 0}) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDriver(t, nil)
+			if err := tt.setup(d); err != nil {
+				t.Fatal(err)
+			}
+
+			err := d.Txn(func(tx *Tx) error {
+				var doc map[string]interface{}
+				if err := tx.Read("accounts", "a", &doc); err != nil && !errors.Is(err, ErrNotFound) {
+					return err
+				}
+				// Another writer gets in between the read and the commit.
+				if err := tt.race(d); err != nil {
+					return err
+				}
+				return tx.Write("transfers", "t", map[string]interface{}{"from": "a"})
+			})
+			if !errors.Is(err, ErrRevisionMismatch) {
+				t.Fatalf("Txn: err = %v, want ErrRevisionMismatch", err)
+			}
+			var doc map[string]interface{}
+			if err := d.Read("transfers", "t", &doc); !errors.Is(err, ErrNotFound) {
+				t.Errorf("transfers/t: err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestTxnRacingWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("counters", "c", map[string]interface{}{"n": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transactions and plain updates increment the same counter; no
+	// increment may be lost.
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				err := d.Txn(func(tx *Tx) error {
+					var doc map[string]interface{}
+					if err := tx.Read("counters", "c", &doc); err != nil {
+						return err
+					}
+					return tx.Write("counters", "c", map[string]interface{}{"n": doc["n"].(float64) + 1})
+				})
+				if !errors.Is(err, ErrRevisionMismatch) {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := d.Update("counters", "c", map[string]interface{}{"$inc": map[string]interface{}{"n": 1}})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("counters", "c", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["n"] != float64(2*n) {
+		t.Errorf("n = %v, want %d", doc["n"], 2*n)
+	}
+}
@@ -0,0 +1,88 @@
+package db
+
+// checkUnique makes sure that applying ops would not give two resources
+// the same key in any unique index. Operations earlier in ops are taken
+// into account, so a transaction may move a key from one resource to
+// another. Documents that have expired but are not deleted yet hold no
+// keys. The caller must hold the collection mutexes.
+func (d *Driver) checkUnique(ops []walOp) error {
+	// staged holds the state each operation leaves behind: the new
+	// document of a written resource, or nil for a deleted one.
+	staged := make(map[string]map[string]map[string]interface{})
+	cleared := make(map[string]bool)
+
+	for _, op := range ops {
+		cs := d.state(op.Collection)
+		if cs == nil {
+			continue
+		}
+		if staged[op.Collection] == nil {
+			staged[op.Collection] = make(map[string]map[string]interface{})
+		}
+		batch := staged[op.Collection]
+
+		switch op.Kind {
+		case opDelete:
+			batch[op.Resource] = nil
+			continue
+		case opDeleteAll:
+			staged[op.Collection] = make(map[string]map[string]interface{})
+			cleared[op.Collection] = true
+			continue
+		}
+
+		doc := decodeObject(op.Data)
+		exp := d.expiry(op.Collection)
+		cs.mu.RLock()
+		for _, idx := range cs.indexes {
+			if !idx.spec.Unique {
+				continue
+			}
+			for _, key := range idx.indexKeys(doc) {
+				if holder, ok := stagedHolder(idx, key, op.Resource, batch); ok {
+					cs.mu.RUnlock()
+					return &DuplicateKeyError{Collection: op.Collection, Index: idx.spec.Name, Key: key, Resource: holder}
+				}
+				if cleared[op.Collection] {
+					continue
+				}
+				for _, holder := range idx.holders(key) {
+					if _, ok := batch[holder]; holder == op.Resource || ok || d.expiredRecord(op.Collection, holder, exp) {
+						continue
+					}
+					cs.mu.RUnlock()
+					return &DuplicateKeyError{Collection: op.Collection, Index: idx.spec.Name, Key: key, Resource: holder}
+				}
+			}
+		}
+		cs.mu.RUnlock()
+		batch[op.Resource] = doc
+	}
+	return nil
+}
+
+// stagedHolder looks for another resource written earlier in the same
+// batch under key.
+func stagedHolder(idx *index, key []interface{}, resource string, batch map[string]map[string]interface{}) (string, bool) {
+	for other, doc := range batch {
+		if other == resource || doc == nil {
+			continue
+		}
+		for _, k := range idx.indexKeys(doc) {
+			if compareKeys(k, key) == 0 {
+				return other, true
+			}
+		}
+	}
+	return "", false
+}
+
+// expiredRecord reports whether collection/resource is still stored but
+// has expired according to exp.
+func (d *Driver) expiredRecord(collection, resource string, exp *expiry) bool {
+	if exp == nil {
+		return false
+	}
+	b, err := d.readStored(collection, resource)
+	return err == nil && exp.expiredRaw(b)
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUniqueIndex(t *testing.T) {
+	type doc = map[string]interface{}
+	tests := []struct {
+		name   string
+		opts   IndexOptions
+		stored map[string]doc
+		write  func(d *Driver) error
+		dup    bool
+	}{
+		{
+			name:   "duplicate key",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write:  func(d *Driver) error { return d.Write("c", "b", doc{"email": "x"}) },
+			dup:    true,
+		},
+		{
+			name:   "rewrite keeping the key",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write:  func(d *Driver) error { return d.Write("c", "a", doc{"email": "x", "n": 1}) },
+		},
+		{
+			name:   "key freed by a rewrite",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write: func(d *Driver) error {
+				if err := d.Write("c", "a", doc{"email": "y"}); err != nil {
+					return err
+				}
+				return d.Write("c", "b", doc{"email": "x"})
+			},
+		},
+		{
+			name:   "key freed by a delete",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write: func(d *Driver) error {
+				if err := d.Delete("c", "a"); err != nil {
+					return err
+				}
+				return d.Write("c", "b", doc{"email": "x"})
+			},
+		},
+		{
+			name:   "missing fields share the null key",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"n": 1}},
+			write:  func(d *Driver) error { return d.Write("c", "b", doc{"n": 2}) },
+			dup:    true,
+		},
+		{
+			name:   "sparse index skips missing fields",
+			opts:   IndexOptions{Unique: true, Sparse: true},
+			stored: map[string]doc{"a": {"n": 1}},
+			write:  func(d *Driver) error { return d.Write("c", "b", doc{"n": 2}) },
+		},
+		{
+			name:   "sparse index constrains set fields",
+			opts:   IndexOptions{Unique: true, Sparse: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write:  func(d *Driver) error { return d.Write("c", "b", doc{"email": "x"}) },
+			dup:    true,
+		},
+		{
+			name:   "transaction moving a key",
+			opts:   IndexOptions{Unique: true},
+			stored: map[string]doc{"a": {"email": "x"}},
+			write: func(d *Driver) error {
+				return d.Txn(func(tx *Tx) error {
+					if err := tx.Delete("c", "a"); err != nil {
+						return err
+					}
+					return tx.Write("c", "b", doc{"email": "x"})
+				})
+			},
+		},
+		{
+			name: "transaction writing a key twice",
+			opts: IndexOptions{Unique: true},
+			write: func(d *Driver) error {
+				return d.Txn(func(tx *Tx) error {
+					if err := tx.Write("c", "a", doc{"email": "x"}); err != nil {
+						return err
+					}
+					return tx.Write("c", "b", doc{"email": "x"})
+				})
+			},
+			dup: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDriver(t, nil)
+			for resource, doc := range tt.stored {
+				if err := d.Write("c", resource, doc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := d.CreateIndex("c", "email", &tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			err := tt.write(d)
+			var dup *DuplicateKeyError
+			if tt.dup != errors.As(err, &dup) || (!tt.dup && err != nil) {
+				t.Fatalf("err = %v, want a duplicate key error: %v", err, tt.dup)
+			}
+		})
+	}
+}
+
+func TestUniqueIndexOverDuplicates(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, resource := range []string{"a", "b"} {
+		if err := d.Write("c", resource, map[string]interface{}{"email": "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var dup *DuplicateKeyError
+	if _, err := d.CreateIndex("c", "email", &IndexOptions{Unique: true}); !errors.As(err, &dup) {
+		t.Fatalf("err = %v, want a duplicate key error", err)
+	}
+	if specs := d.ListIndexes("c"); len(specs) != 0 {
+		t.Errorf("failed index was kept: %+v", specs)
+	}
+}
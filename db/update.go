@@ -0,0 +1,321 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidUpdate is returned when an update document is malformed or
+// cannot be applied to the stored record.
+var ErrInvalidUpdate = errors.New("invalid update")
+
+// updateOperators lists the supported update operators in the order in
+// which they are applied.
+var updateOperators = []string{
+	"$set", "$unset", "$rename", "$inc", "$mul", "$min", "$max",
+	"$push", "$addToSet", "$pull",
+}
+
+// Update applies a MongoDB-style update document such as
+// {"$set": {"city": "Austin"}, "$inc": {"visits": 1}} to an existing
+// resource and returns the updated record. The read, modify and write
+// happen under the collection mutex, so concurrent updates touching
+// different fields do not lose each other's changes.
+func (d *Driver) Update(collection, resource string, update map[string]interface{}) (map[string]interface{}, error) {
+	if collection == "" {
+		return nil, fmt.Errorf("Missing collection - unable to update!")
+	}
+
+	if resource == "" {
+		return nil, fmt.Errorf("Missing resource - unable to update record (no name)!")
+	}
+
+	ops, err := parseUpdate(update)
+	if err != nil {
+		return nil, err
+	}
+
+	mutex := d.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var doc map[string]interface{}
+	if err := d.Read(collection, resource, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+
+	if err := applyUpdate(doc, ops); err != nil {
+		return nil, err
+	}
+
+	b, err := d.store(collection, resource, doc)
+	if err != nil {
+		return nil, err
+	}
+	return decodeObject(b), nil
+}
+
+// parseUpdate validates an update document and normalizes its operands to
+// plain JSON values.
+func parseUpdate(update map[string]interface{}) (map[string]map[string]interface{}, error) {
+	if len(update) == 0 {
+		return nil, fmt.Errorf("%w: empty update document", ErrInvalidUpdate)
+	}
+
+	normalized, err := normalize(update)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+
+	ops := make(map[string]map[string]interface{})
+	for op, fields := range normalized.(map[string]interface{}) {
+		if !isUpdateOperator(op) {
+			return nil, fmt.Errorf("%w: unknown update operator %q", ErrInvalidUpdate, op)
+		}
+		m, ok := fields.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects an object of fields", ErrInvalidUpdate, op)
+		}
+		ops[op] = m
+	}
+	return ops, nil
+}
+
+func isUpdateOperator(op string) bool {
+	for _, known := range updateOperators {
+		if op == known {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUpdate applies parsed update operators to doc in place.
+func applyUpdate(doc map[string]interface{}, ops map[string]map[string]interface{}) error {
+	for _, op := range updateOperators {
+		fields, ok := ops[op]
+		if !ok {
+			continue
+		}
+
+		paths := make([]string, 0, len(fields))
+		for path := range fields {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			if err := applyOperator(doc, op, path, fields[path]); err != nil {
+				return fmt.Errorf("%w: %s on %q: %v", ErrInvalidUpdate, op, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyOperator(doc map[string]interface{}, op, path string, arg interface{}) error {
+	current, exists := getField(doc, path)
+
+	switch op {
+	case "$set":
+		return setField(doc, path, arg)
+
+	case "$unset":
+		unsetField(doc, path)
+		return nil
+
+	case "$rename":
+		target, ok := arg.(string)
+		if !ok || target == "" {
+			return fmt.Errorf("target field name must be a non-empty string")
+		}
+		if !exists {
+			return nil
+		}
+		unsetField(doc, path)
+		return setField(doc, target, current)
+
+	case "$inc", "$mul":
+		delta, ok := toFloat(arg)
+		if !ok {
+			return fmt.Errorf("operand must be a number")
+		}
+		if !exists {
+			if op == "$mul" {
+				delta = 0
+			}
+			return setField(doc, path, delta)
+		}
+		value, ok := toFloat(current)
+		if !ok {
+			return fmt.Errorf("field holds a non-numeric value")
+		}
+		if op == "$inc" {
+			return setField(doc, path, value+delta)
+		}
+		return setField(doc, path, value*delta)
+
+	case "$min", "$max":
+		if !exists {
+			return setField(doc, path, arg)
+		}
+		cmp, ok := compareValues(arg, current)
+		if !ok {
+			return fmt.Errorf("cannot compare %T with %T", arg, current)
+		}
+		if (op == "$min" && cmp < 0) || (op == "$max" && cmp > 0) {
+			return setField(doc, path, arg)
+		}
+		return nil
+
+	case "$push", "$addToSet":
+		var arr []interface{}
+		if exists {
+			var ok bool
+			if arr, ok = current.([]interface{}); !ok {
+				return fmt.Errorf("field holds a non-array value")
+			}
+		}
+		items, mods, err := eachItems(op, arg)
+		if err != nil {
+			return err
+		}
+		if op == "$push" {
+			arr = mods.push(arr, items)
+		}
+		for _, item := range items {
+			if op == "$addToSet" && !containsValue(arr, item) {
+				arr = append(arr, item)
+			}
+		}
+		if arr == nil {
+			arr = []interface{}{}
+		}
+		return setField(doc, path, arr)
+
+	case "$pull":
+		if !exists {
+			return nil
+		}
+		arr, ok := current.([]interface{})
+		if !ok {
+			return fmt.Errorf("field holds a non-array value")
+		}
+		if m, ok := arg.(map[string]interface{}); ok && isModifierObject(m) {
+			return fmt.Errorf("conditions are not supported, only values to remove")
+		}
+		kept := make([]interface{}, 0, len(arr))
+		for _, item := range arr {
+			if !valuesEqual(item, arg) {
+				kept = append(kept, item)
+			}
+		}
+		return setField(doc, path, kept)
+	}
+	return fmt.Errorf("unsupported operator")
+}
+
+// pushModifiers holds the $position and $slice modifiers of a $push.
+type pushModifiers struct {
+	position, slice *int
+}
+
+// eachItems expands the {"$each": [...]} modifier accepted by $push and
+// $addToSet; any other operand is a single item. Next to $each, $push
+// accepts $position and $slice.
+func eachItems(op string, arg interface{}) ([]interface{}, pushModifiers, error) {
+	var mods pushModifiers
+	m, ok := arg.(map[string]interface{})
+	if !ok || !isModifierObject(m) {
+		return []interface{}{arg}, mods, nil
+	}
+
+	each, ok := m["$each"].([]interface{})
+	if !ok {
+		return nil, mods, fmt.Errorf("$each expects an array")
+	}
+	for key, v := range m {
+		var target **int
+		switch key {
+		case "$each":
+			continue
+		case "$position":
+			target = &mods.position
+		case "$slice":
+			target = &mods.slice
+		default:
+			return nil, mods, fmt.Errorf("unsupported modifier %q", key)
+		}
+		if op != "$push" {
+			return nil, mods, fmt.Errorf("%s only accepts $each", op)
+		}
+		n, ok := toFloat(v)
+		if !ok || n != math.Trunc(n) {
+			return nil, mods, fmt.Errorf("%s expects an integer", key)
+		}
+		i := int(n)
+		*target = &i
+	}
+	return each, mods, nil
+}
+
+// isModifierObject reports whether m holds modifiers rather than being a
+// plain sub-document: any of its keys starts with "$".
+func isModifierObject(m map[string]interface{}) bool {
+	for key := range m {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// push inserts items into arr at the requested position, from the end
+// when it is negative, and then keeps the first or, when negative, the
+// last slice elements.
+func (m pushModifiers) push(arr, items []interface{}) []interface{} {
+	pos := len(arr)
+	if m.position != nil {
+		pos = *m.position
+		if pos < 0 {
+			pos += len(arr)
+		}
+		if pos < 0 {
+			pos = 0
+		}
+		if pos > len(arr) {
+			pos = len(arr)
+		}
+	}
+
+	out := make([]interface{}, 0, len(arr)+len(items))
+	out = append(out, arr[:pos]...)
+	out = append(out, items...)
+	out = append(out, arr[pos:]...)
+
+	if m.slice != nil {
+		n := *m.slice
+		switch {
+		case n >= 0 && n < len(out):
+			out = out[:n]
+		case n < 0 && -n < len(out):
+			out = out[len(out)+n:]
+		}
+	}
+	return out
+}
+
+func containsValue(arr []interface{}, v interface{}) bool {
+	for _, item := range arr {
+		if valuesEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		update string
+		want   string
+	}{
+		{"set", `{"a": 1}`, `{"$set": {"b.c": 2}}`, `{"a": 1, "b": {"c": 2}}`},
+		{"unset", `{"a": 1, "b": 2}`, `{"$unset": {"a": ""}}`, `{"b": 2}`},
+		{"rename", `{"a": 1}`, `{"$rename": {"a": "b"}}`, `{"b": 1}`},
+		{"inc", `{"n": 1}`, `{"$inc": {"n": 2, "m": 3}}`, `{"n": 3, "m": 3}`},
+		{"mul", `{"n": 2}`, `{"$mul": {"n": 4, "m": 3}}`, `{"n": 8, "m": 0}`},
+		{"min", `{"n": 5}`, `{"$min": {"n": 3, "m": 1}}`, `{"n": 3, "m": 1}`},
+		{"max", `{"n": 5}`, `{"$max": {"n": 3}}`, `{"n": 5}`},
+		{"push", `{"a": [1]}`, `{"$push": {"a": 2}}`, `{"a": [1, 2]}`},
+		{"push each", `{}`, `{"$push": {"a": {"$each": [1, 2]}}}`, `{"a": [1, 2]}`},
+		{"push position", `{"a": [1, 4]}`, `{"$push": {"a": {"$each": [2, 3], "$position": 1}}}`, `{"a": [1, 2, 3, 4]}`},
+		{"push negative position", `{"a": [1, 4]}`, `{"$push": {"a": {"$each": [2], "$position": -1}}}`, `{"a": [1, 2, 4]}`},
+		{"push slice", `{"a": [1, 2]}`, `{"$push": {"a": {"$each": [3, 4], "$slice": -3}}}`, `{"a": [2, 3, 4]}`},
+		{"push slice front", `{"a": [1, 2]}`, `{"$push": {"a": {"$each": [3], "$slice": 2}}}`, `{"a": [1, 2]}`},
+		{"push sub-document", `{}`, `{"$push": {"a": {"b": 1}}}`, `{"a": [{"b": 1}]}`},
+		{"addToSet", `{"a": [1]}`, `{"$addToSet": {"a": {"$each": [1, 2, 2]}}}`, `{"a": [1, 2]}`},
+		{"pull", `{"a": [1, 2, 1]}`, `{"$pull": {"a": 1}}`, `{"a": [2]}`},
+		{"pull sub-document", `{"a": [{"b": 1}, {"b": 2}]}`, `{"$pull": {"a": {"b": 1}}}`, `{"a": [{"b": 2}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDriver(t, nil)
+			if err := d.Write("c", "r", json.RawMessage(tt.doc)); err != nil {
+				t.Fatal(err)
+			}
+			var update, want map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.update), &update); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			want["_id"] = "r"
+
+			got, err := d.Update("c", "r", update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Update returned %v, want %v", got, want)
+			}
+			var stored map[string]interface{}
+			if err := d.Read("c", "r", &stored); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stored, want) {
+				t.Errorf("stored %v, want %v", stored, want)
+			}
+		})
+	}
+}
+
+func TestUpdateRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		update string
+	}{
+		{"empty", `{}`},
+		{"unknown operator", `{"$frob": {"a": 1}}`},
+		{"operand not an object", `{"$set": 1}`},
+		{"inc non-number", `{"$inc": {"a": "x"}}`},
+		{"inc non-numeric field", `{"$inc": {"s": 1}}`},
+		{"push to non-array", `{"$push": {"s": 1}}`},
+		{"each not an array", `{"$push": {"a": {"$each": 1}}}`},
+		{"unsupported modifier", `{"$push": {"a": {"$each": [1], "$sort": 1}}}`},
+		{"modifier without each", `{"$push": {"a": {"$slice": 1}}}`},
+		{"fractional slice", `{"$push": {"a": {"$each": [1], "$slice": 1.5}}}`},
+		{"addToSet modifier", `{"$addToSet": {"a": {"$each": [1], "$position": 0}}}`},
+		{"pull condition", `{"$pull": {"a": {"$gte": 6}}}`},
+	}
+	d := newTestDriver(t, nil)
+	if err := d.Write("c", "r", map[string]interface{}{"a": []int{5, 6, 7}, "s": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		var update map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.update), &update); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Update("c", "r", update); !errors.Is(err, ErrInvalidUpdate) {
+			t.Errorf("%s: err = %v, want ErrInvalidUpdate", tt.name, err)
+		}
+	}
+
+	var stored map[string]interface{}
+	if err := d.Read("c", "r", &stored); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{5.0, 6.0, 7.0}; !reflect.DeepEqual(stored["a"], want) {
+		t.Errorf("a rejected update changed a to %v", stored["a"])
+	}
+
+	if _, err := d.Update("c", "missing", map[string]interface{}{"$set": map[string]interface{}{"a": 1}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing resource: err = %v, want ErrNotFound", err)
+	}
+}
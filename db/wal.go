@@ -0,0 +1,384 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Durability selects how hard the driver works to get a write onto stable
+// storage before acknowledging it.
+type Durability int
+
+const (
+	// DurabilityNone leaves flushing to the operating system. A crash of
+	// the process is recovered from, a crash of the machine may lose the
+	// most recent writes.
+	DurabilityNone Durability = iota
+	// DurabilitySync fsyncs the write-ahead log before every write returns.
+	DurabilitySync
+	// DurabilityGroup fsyncs the write-ahead log once for all the writes
+	// that queued up while the previous fsync was running.
+	DurabilityGroup
+)
+
+const (
+	// walDir holds the write-ahead log inside the database directory.
+	walDir  = ".wal"
+	walFile = "wal.log"
+
+	// walCheckpointSize is the log size after which the next commit
+	// flushes the record files and truncates the log.
+	walCheckpointSize = 4 << 20
+)
+
+const (
+	opWrite     = "write"
+	opDelete    = "delete"
+	opDeleteAll = "deleteall"
+)
+
+// walOp is a single mutation recorded in the write-ahead log.
+type walOp struct {
+	Kind       string `json:"kind"`
+	Collection string `json:"collection"`
+	Resource   string `json:"resource,omitempty"`
+	Data       []byte `json:"data,omitempty"`
+}
+
+// walRecord is one line of the log. All operations of a record are
+// replayed together, which is what makes transactions atomic.
+type walRecord struct {
+	Seq uint64  `json:"seq"`
+	Ops []walOp `json:"ops"`
+}
+
+// wal is the append-only write-ahead log. Each record is stored as a line
+// of the form "<crc32> <json>\n" so that a torn final line is detected on
+// replay.
+type wal struct {
+	mu     sync.Mutex // guards everything below except synced
+	f      *os.File
+	mode   Durability
+	seq    uint64
+	size   int64
+	dirty  map[string]struct{}
+	broken bool
+
+	syncMu sync.Mutex // serializes group commit fsyncs
+	synced uint64
+}
+
+// append records ops and, depending on the durability mode, waits until
+// the record has reached the disk.
+func (w *wal) append(ops []walOp) error {
+	w.mu.Lock()
+	w.seq++
+	seq := w.seq
+	line, err := encodeWALRecord(walRecord{Seq: seq, Ops: ops})
+	if err == nil {
+		_, err = w.f.Write(line)
+		w.size += int64(len(line))
+	}
+	if err == nil && w.mode == DurabilitySync {
+		err = w.f.Sync()
+	}
+	w.mu.Unlock()
+
+	if err != nil || w.mode != DurabilityGroup {
+		return err
+	}
+	return w.syncUpTo(seq)
+}
+
+// syncUpTo returns once record seq is on disk. Writers that arrive while
+// another one is syncing wait for it and are then usually covered by the
+// next single fsync, so many commits share one flush.
+func (w *wal) syncUpTo(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if w.synced >= seq {
+		return nil
+	}
+
+	w.mu.Lock()
+	last := w.seq
+	w.mu.Unlock()
+
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.synced = last
+	return nil
+}
+
+// touch remembers files and directories that must be flushed before the
+// log may be truncated.
+func (w *wal) touch(paths ...string) {
+	if w.mode == DurabilityNone {
+		return
+	}
+	w.mu.Lock()
+	for _, path := range paths {
+		w.dirty[path] = struct{}{}
+	}
+	w.mu.Unlock()
+}
+
+// reset flushes every file changed since the last reset and empties the
+// log. The caller must make sure no commit is in flight.
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for path := range w.dirty {
+		if err := syncPath(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.dirty = make(map[string]struct{})
+
+	if w.broken {
+		// A logged operation could not be applied; keep it for the
+		// replay on the next start.
+		return nil
+	}
+
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, 0); err != nil {
+		return err
+	}
+	w.size = 0
+	if w.mode != DurabilityNone {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(b), b)
+	return []byte(line), nil
+}
+
+// decodeWAL parses the records of a log. It stops at the first line that
+// is incomplete or fails its checksum, which can only be the tail written
+// during a crash.
+func decodeWAL(b []byte) (records []walRecord, torn bool) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			return records, true
+		}
+		line := b[:i]
+		b = b[i+1:]
+
+		var sum uint32
+		if len(line) < 10 || line[8] != ' ' {
+			return records, true
+		}
+		if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil {
+			return records, true
+		}
+		body := line[9:]
+		if crc32.ChecksumIEEE(body) != sum {
+			return records, true
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(body, &rec); err != nil {
+			return records, true
+		}
+		records = append(records, rec)
+	}
+	return records, false
+}
+
+// openWAL replays the log left by the previous run, removes temporary
+// files orphaned by a crash and starts a fresh log.
+func (d *Driver) openWAL(mode Durability) error {
+	dir := filepath.Join(d.dir, walDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.wal = &wal{f: f, mode: mode, dirty: make(map[string]struct{})}
+
+	if err := d.loadCatalog(); err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	records, torn := decodeWAL(b)
+	if torn {
+		d.log.Warn("Discarding incomplete write-ahead log record in %s", path)
+	}
+	if len(records) > 0 {
+		d.log.Info("Replaying %d write-ahead log records", len(records))
+	}
+	for _, rec := range records {
+		if err := d.applyOps(rec.Ops); err != nil {
+			return err
+		}
+		d.wal.seq = rec.Seq
+	}
+
+	if err := d.removeTempFiles(); err != nil {
+		return err
+	}
+	return d.checkpoint()
+}
+
+// removeTempFiles deletes the ".tmp" files of writes that never reached
+// their rename.
+func (d *Driver) removeTempFiles() error {
+	collections, err := d.collections()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		dir := filepath.Join(d.dir, collection)
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if filepath.Ext(file.Name()) != ".tmp" {
+				continue
+			}
+			d.log.Debug("Removing orphaned temporary file: %s", file.Name())
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+			d.wal.touch(dir)
+		}
+	}
+	return nil
+}
+
+// commit stamps the documents written by ops (see stamp), then logs and
+// applies ops together with the evictions they cause in capped
+// collections. The evictions come first, so that the documents they
+// delete hold no unique keys against the writes. The caller must hold
+// the mutexes of every collection the operations touch.
+func (d *Driver) commit(ops []walOp) error {
+	if err := d.stamp(ops); err != nil {
+		return err
+	}
+	evictions, err := d.cappedEvictions(ops)
+	if err != nil {
+		return err
+	}
+	ops = append(evictions, ops...)
+
+	if err := d.checkSchemas(ops); err != nil {
+		return err
+	}
+	if err := d.checkUnique(ops); err != nil {
+		return err
+	}
+
+	d.ckpt.RLock()
+	err = d.wal.append(ops)
+	if err == nil {
+		if err = d.applyOps(ops); err != nil {
+			d.log.Error("Logged operation could not be applied, it will be replayed on restart: %s", err)
+			d.wal.mu.Lock()
+			d.wal.broken = true
+			d.wal.mu.Unlock()
+		}
+	}
+	d.ckpt.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	d.wal.mu.Lock()
+	full := d.wal.size > walCheckpointSize
+	d.wal.mu.Unlock()
+	if full {
+		return d.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint waits for in-flight commits, makes their effects durable and
+// truncates the log.
+func (d *Driver) checkpoint() error {
+	d.ckpt.Lock()
+	defer d.ckpt.Unlock()
+
+	if err := d.saveIndexes(); err != nil {
+		return err
+	}
+	return d.wal.reset()
+}
+
+// applyOps performs ops in order. Every operation is idempotent so that a
+// record can be replayed any number of times.
+func (d *Driver) applyOps(ops []walOp) error {
+	for _, op := range ops {
+		dir := filepath.Join(d.dir, op.Collection)
+		switch op.Kind {
+		case opWrite:
+			if err := d.writeBytes(op.Collection, op.Resource, op.Data); err != nil {
+				return err
+			}
+		case opDelete:
+			err := os.Remove(filepath.Join(dir, op.Resource+".json"))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			d.wal.touch(dir)
+		case opDeleteAll:
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			d.wal.touch(d.dir)
+		default:
+			return fmt.Errorf("unknown write-ahead log operation %q", op.Kind)
+		}
+		d.updateIndexes(op)
+	}
+	return nil
+}
+
+// Close stops the reaper, flushes pending changes, empties the write-ahead
+// log and releases it. The driver must not be used afterwards.
+func (d *Driver) Close() error {
+	d.stopReaper()
+	if err := d.checkpoint(); err != nil {
+		return err
+	}
+	return d.wal.f.Close()
+}
+
+// syncPath fsyncs a file or directory by name.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// crash abandons d as a killed process would: its write-ahead log is
+// closed without the checkpoint that Close makes.
+func crash(t *testing.T, d *Driver) {
+	t.Helper()
+	d.stopReaper()
+	if err := d.wal.f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// logOnly records ops in the write-ahead log of d without applying them,
+// as if the process died right after logging them.
+func logOnly(t *testing.T, d *Driver, ops ...walOp) {
+	t.Helper()
+	if err := d.wal.append(ops); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeOp(t *testing.T, collection, resource string, v interface{}) walOp {
+	t.Helper()
+	b, err := encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walOp{Kind: opWrite, Collection: collection, Resource: resource, Data: b}
+}
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1", "3"} {
+		if err := d.Write("c", name, map[string]interface{}{"n": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logOnly(t, d,
+		writeOp(t, "c", "1", map[string]interface{}{"n": 2}),
+		writeOp(t, "c", "2", map[string]interface{}{"n": 3}),
+		walOp{Kind: opDelete, Collection: "c", Resource: "3"})
+
+	// A record torn by the crash and the temporary file of a write that
+	// never reached its rename.
+	logOnly(t, d, writeOp(t, "c", "4", map[string]interface{}{"n": 4}))
+	path := filepath.Join(dir, walDir, walFile)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b[:len(b)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "c", "5.json.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, d)
+
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	for name, want := range map[string]float64{"1": 2, "2": 3} {
+		var doc map[string]interface{}
+		if err := d.Read("c", name, &doc); err != nil {
+			t.Fatalf("c/%s: %v", name, err)
+		}
+		if doc["n"] != want {
+			t.Errorf("c/%s: n = %v, want %v", name, doc["n"], want)
+		}
+	}
+	for _, name := range []string{"3", "4"} {
+		var doc map[string]interface{}
+		if err := d.Read("c", name, &doc); !errors.Is(err, ErrNotFound) {
+			t.Errorf("c/%s: err = %v, want ErrNotFound", name, err)
+		}
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("log was not emptied after replay: %v", err)
+	}
+}
+
+func TestWALReplayIsIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	d, err := New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "1", map[string]interface{}{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is applied and logged twice, as when a crash interrupts
+	// the checkpoint that follows it.
+	ops := []walOp{
+		writeOp(t, "c", "2", map[string]interface{}{"n": 2}),
+		{Kind: opDelete, Collection: "c", Resource: "1"},
+	}
+	if err := d.applyOps(ops); err != nil {
+		t.Fatal(err)
+	}
+	logOnly(t, d, ops...)
+	logOnly(t, d, ops...)
+	crash(t, d)
+
+	d, err = New(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	records, err := d.ReadAll("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1: %s", len(records), records)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("c", "2", &doc); err != nil || doc["n"] != 2.0 {
+		t.Errorf("c/2 = %v, %v; want n 2", doc, err)
+	}
+}
+
+func TestDurabilityModes(t *testing.T) {
+	for _, mode := range []Durability{DurabilityNone, DurabilitySync, DurabilityGroup} {
+		d := newTestDriver(t, &Options{Durability: mode})
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- d.Write("c", fmt.Sprint(i), map[string]interface{}{"n": i})
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("durability %d: %v", mode, err)
+			}
+		}
+
+		records, err := d.ReadAll("c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 10 {
+			t.Errorf("durability %d: %d records, want 10", mode, len(records))
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+// CappedHandler shows (GET), sets (POST) or removes (DELETE) the limits of
+// a capped collection.
+func CappedHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		spec := database.Capped(collection)
+		if spec == nil {
+			http.Error(w, fmt.Sprintf("%s is not capped", collection), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(spec)
+
+	case http.MethodPost:
+		spec := &db.CappedSpec{}
+		if v := r.URL.Query().Get("maxDocs"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid maxDocs %q", v), http.StatusBadRequest)
+				return
+			}
+			spec.MaxDocs = n
+		}
+		if v := r.URL.Query().Get("maxBytes"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid maxBytes %q", v), http.StatusBadRequest)
+				return
+			}
+			spec.MaxBytes = n
+		}
+		if spec.MaxDocs == 0 && spec.MaxBytes == 0 {
+			http.Error(w, "Missing maxDocs or maxBytes", http.StatusBadRequest)
+			return
+		}
+		if err := database.SetCapped(collection, spec); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(spec)
+
+	case http.MethodDelete:
+		if err := database.SetCapped(collection, nil); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
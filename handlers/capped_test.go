@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCappedHandler(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+		want   string
+	}{
+		{"get without collection", http.MethodGet, "/capped", http.StatusBadRequest, ""},
+		{"get uncapped", http.MethodGet, "/capped?collection=log", http.StatusNotFound, ""},
+		{"set without limits", http.MethodPost, "/capped?collection=log", http.StatusBadRequest, ""},
+		{"set with bad maxDocs", http.MethodPost, "/capped?collection=log&maxDocs=x", http.StatusBadRequest, ""},
+		{"set with negative maxBytes", http.MethodPost, "/capped?collection=log&maxBytes=-1", http.StatusBadRequest, ""},
+		{"set", http.MethodPost, "/capped?collection=log&maxDocs=2", http.StatusOK, `{"maxDocs":2}`},
+		{"get", http.MethodGet, "/capped?collection=log", http.StatusOK, `{"maxDocs":2}`},
+		{"put", http.MethodPut, "/capped?collection=log", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(CappedHandler, tt.method, tt.target, "")
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.want != "" && got != tt.want {
+			t.Errorf("%s: body %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, resource := range []string{"c", "b", "a"} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=log&resource="+resource, `{"r": "`+resource+`"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", resource, rec.Code, rec.Body)
+		}
+	}
+	rec := serve(ReadAllResourcesHandler, http.MethodGet, "/readall?collection=log", "")
+	if got := strings.TrimSpace(rec.Body.String()); got != `[{"_id":"b","r":"b"},{"_id":"a","r":"a"}]` {
+		t.Errorf("readall: %s, want b then a", got)
+	}
+
+	if rec := serve(CappedHandler, http.MethodDelete, "/capped?collection=log", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(CappedHandler, http.MethodGet, "/capped?collection=log", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get after delete: status %d", rec.Code)
+	}
+}
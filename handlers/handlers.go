@@ -2,22 +2,135 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Sakthe-Balan/GoMongoDB/db"
 )
 
 var database *db.Driver
 
-func InitDB(dir string) {
+// InitDB opens the database the handlers serve. A nil opts uses the
+// defaults of db.New.
+func InitDB(dir string, opts *db.Options) {
 	var err error
-	database, err = db.New(dir, nil)
+	database, err = db.New(dir, opts)
 	if err != nil {
 		fmt.Println("Error initializing database:", err)
 	}
 }
 
+// errorStatus maps errors returned by the db package to HTTP status codes.
+func errorStatus(err error) int {
+	var dup *db.DuplicateKeyError
+	var invalid *db.ValidationError
+	switch {
+	case errors.As(err, &dup):
+		return http.StatusConflict
+	case errors.As(err, &invalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrInvalidUpdate), errors.Is(err, db.ErrInvalidQuery),
+		errors.Is(err, db.ErrInvalidSchema), errors.Is(err, db.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrRevisionMismatch):
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError reports a failed write. Documents rejected by the schema of
+// their collection get a JSON body listing every violation.
+func writeError(w http.ResponseWriter, err error) {
+	var invalid *db.ValidationError
+	if !errors.As(err, &invalid) {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  err.Error(),
+		"errors": invalid.Errors,
+	})
+}
+
+// documentBody reads a request body holding a JSON object. It is kept
+// encoded, so that the order of its fields and the exact value of its
+// numbers reach the store untouched.
+func documentBody(r *http.Request) (json.RawMessage, error) {
+	var data json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data[0] != '{' {
+		return nil, errors.New("Request body must be a JSON object")
+	}
+	return data, nil
+}
+
+// documentID returns the "_id" of a document read by documentBody, or ""
+// if it has none.
+func documentID(data json.RawMessage) (string, error) {
+	var doc struct {
+		ID json.RawMessage `json:"_id"`
+	}
+	json.Unmarshal(data, &doc)
+	if doc.ID == nil {
+		return "", nil
+	}
+	var id string
+	if json.Unmarshal(doc.ID, &id) != nil || id == "" {
+		return "", errors.New("The _id of a document must be a non-empty string")
+	}
+	return id, nil
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// lists rev. An empty rev stands for a missing resource, which no tag
+// (not even "*") matches.
+func etagMatches(header, rev string) bool {
+	if rev == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.Trim(strings.TrimPrefix(tag, "W/"), `"`) == rev {
+			return true
+		}
+	}
+	return false
+}
+
+func setETag(w http.ResponseWriter, rev string) {
+	w.Header().Set("ETag", `"`+rev+`"`)
+}
+
+// parseWriteMode reads the optional "mode" query parameter of /write.
+func parseWriteMode(mode string) (db.WriteMode, error) {
+	switch mode {
+	case "", "upsert":
+		return db.Upsert, nil
+	case "insert":
+		return db.InsertOnly, nil
+	case "replace":
+		return db.ReplaceOnly, nil
+	}
+	return db.Upsert, fmt.Errorf("Unknown write mode %q (use upsert, insert or replace)", mode)
+}
+
 func CreateResourceHandler(w http.ResponseWriter, r *http.Request) {
 	collection := r.URL.Query().Get("collection")
 	resource := r.URL.Query().Get("resource")
@@ -26,18 +139,123 @@ func CreateResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+	mode, err := parseWriteMode(r.URL.Query().Get("mode"))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := database.Write(collection, resource, data); err != nil {
+	opts := &db.WriteOptions{Mode: mode}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid ttl %q (use a duration such as 30m)", ttl), http.StatusBadRequest)
+			return
+		}
+		opts.ExpiresAt = time.Now().Add(d)
+	}
+
+	data, err := documentBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		if err := database.WriteWith(collection, resource, data, opts); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if !opts.ExpiresAt.IsZero() {
+		http.Error(w, "A ttl cannot be combined with a conditional write", http.StatusBadRequest)
+		return
+	}
+
+	// Conditional write: check the mode and the preconditions against the
+	// current revision, then let WriteIf make sure it has not moved in
+	// between.
+	current, err := database.Rev(collection, resource)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if mode == db.InsertOnly && current != "" {
+		http.Error(w, fmt.Sprintf("%s: %s/%s", db.ErrAlreadyExists, collection, resource), http.StatusConflict)
+		return
+	}
+	if mode == db.ReplaceOnly && current == "" {
+		http.Error(w, fmt.Sprintf("%s: %s/%s", db.ErrNotFound, collection, resource), http.StatusNotFound)
+		return
+	}
+	if (ifMatch != "" && !etagMatches(ifMatch, current)) || (ifNoneMatch != "" && etagMatches(ifNoneMatch, current)) {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	rev, err := database.WriteIf(collection, resource, current, data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, rev)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// InsertHandler stores the request body as a new document. Its resource
+// name is the resource parameter, the "_id" of the body, or else a new
+// server-generated ID; it is stored in the document as "_id" and returned
+// in the response body and the Location header.
+func InsertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	data, err := documentBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := documentID(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if resource := r.URL.Query().Get("resource"); resource != "" {
+		if id != "" && id != resource {
+			http.Error(w, "The resource name and the _id of the document differ", http.StatusBadRequest)
+			return
+		}
+		// The write stores the resource name as the _id.
+		id = resource
+		err = database.WriteWith(collection, id, data, &db.WriteOptions{Mode: db.InsertOnly})
+	} else {
+		id, err = database.Insert(collection, data)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
+	location := url.URL{Path: "/read", RawQuery: url.Values{"collection": {collection}, "resource": {id}}.Encode()}
+	w.Header().Set("Location", location.String())
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"_id": id})
 }
 
 func ReadResourceHandler(w http.ResponseWriter, r *http.Request) {
@@ -48,12 +266,63 @@ func ReadResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data map[string]interface{}
-	if err := database.Read(collection, resource, &data); err != nil {
+	if r.Method == http.MethodHead {
+		exists, err := database.Exists(collection, resource)
+		switch {
+		case err != nil:
+			w.WriteHeader(errorStatus(err))
+		case !exists:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	var data json.RawMessage
+	rev, err := database.ReadRev(collection, resource, &data)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
+	if h := r.Header.Get("If-Match"); h != "" && !etagMatches(h, rev) {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	setETag(w, rev)
+	if h := r.Header.Get("If-None-Match"); h != "" && etagMatches(h, rev) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	json.NewEncoder(w).Encode(data)
+}
+
+func UpdateResourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collection := r.URL.Query().Get("collection")
+	resource := r.URL.Query().Get("resource")
+	if collection == "" || resource == "" {
+		http.Error(w, "Missing collection or resource name", http.StatusBadRequest)
+		return
+	}
+
+	var update map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := database.Update(collection, resource, update)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
 	json.NewEncoder(w).Encode(data)
 }
 
@@ -64,9 +333,36 @@ func ReadAllResourcesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	records, err := database.ReadAll(collection)
+	opts, err := findOptionsParam(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsNDJSON(r) && opts.Sort == nil && opts.Projection == nil &&
+		opts.Skip == 0 && opts.Limit == 0 && opts.Cursor == "" {
+		stream := &ndjsonWriter{w: w}
+		stream.finish(database.Iterate(collection, func(resource string, record json.RawMessage) error {
+			return stream.write(record)
+		}))
+		return
+	}
+
+	records, next, err := database.ReadAllWith(collection, &opts)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	setNextCursor(w, next)
+
+	if wantsNDJSON(r) {
+		stream := &ndjsonWriter{w: w}
+		for _, record := range records {
+			if err = stream.write(record); err != nil {
+				break
+			}
+		}
+		stream.finish(err)
 		return
 	}
 
@@ -114,6 +410,77 @@ func DeleteAllHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// collectionsParam collects the "collection" query parameter, which may be
+// repeated or hold a comma-separated list.
+func collectionsParam(r *http.Request) []string {
+	var collections []string
+	for _, value := range r.URL.Query()["collection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				collections = append(collections, name)
+			}
+		}
+	}
+	return collections
+}
+
+// listParam splits a comma-separated query parameter.
+func listParam(r *http.Request, name string) []string {
+	var items []string
+	for _, item := range strings.Split(r.URL.Query().Get(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// findOptionsParam reads the sort, projection, skip, limit, page_size and
+// cursor query parameters. sort and projection take comma-separated field
+// paths; a leading "-" sorts a field in descending order or excludes it
+// from the projection. page_size is an alias of limit for paging with
+// cursor.
+func findOptionsParam(r *http.Request) (db.FindOptions, error) {
+	opts := db.FindOptions{Cursor: r.URL.Query().Get("cursor")}
+	for _, field := range listParam(r, "sort") {
+		opts.Sort = append(opts.Sort, db.SortField{
+			Path: strings.TrimPrefix(field, "-"),
+			Desc: strings.HasPrefix(field, "-"),
+		})
+	}
+
+	for _, field := range listParam(r, "projection") {
+		if opts.Projection == nil {
+			opts.Projection = make(map[string]bool)
+		}
+		opts.Projection[strings.TrimPrefix(field, "-")] = !strings.HasPrefix(field, "-")
+	}
+
+	for _, name := range []string{"skip", "limit", "page_size"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("Invalid %s %q", name, value)
+		}
+		if name == "skip" {
+			opts.Skip = n
+		} else {
+			opts.Limit = n
+		}
+	}
+	return opts, nil
+}
+
+// setNextCursor tells the client how to fetch the next page of a result.
+func setNextCursor(w http.ResponseWriter, cursor string) {
+	if cursor != "" {
+		w.Header().Set("X-Next-Cursor", cursor)
+	}
+}
+
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	var query map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
@@ -121,13 +488,39 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := database.Search(query)
+	find, err := findOptionsParam(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(results)
+	opts := &db.SearchOptions{
+		Collections: collectionsParam(r),
+		Documents:   r.URL.Query().Get("documents") == "true",
+		FindOptions: find,
+		Explain:     r.URL.Query().Get("explain") == "true",
+	}
+	results, err := database.Search(query, opts)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if opts.Explain {
+		json.NewEncoder(w).Encode(results.Explain)
+		return
+	}
+	setNextCursor(w, results.NextCursor)
+
+	if opts.Documents {
+		records := results.Records
+		if records == nil {
+			records = []db.Record{}
+		}
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+	json.NewEncoder(w).Encode(results.Resources())
 }
 
 func RegexSearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -143,11 +536,223 @@ func RegexSearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wantsNDJSON(r) {
+		stream := &ndjsonWriter{w: w}
+		stream.finish(database.RegexSearchEach(collection, query, func(record map[string]interface{}) error {
+			return stream.encode(record)
+		}))
+		return
+	}
+
 	results, err := database.RegexSearch(collection, query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+// txnRequest is the body accepted by TxnHandler.
+type txnRequest struct {
+	Ops []struct {
+		Op         string                 `json:"op"`
+		Collection string                 `json:"collection"`
+		Resource   string                 `json:"resource"`
+		Data       map[string]interface{} `json:"data"`
+	} `json:"ops"`
+}
+
+func TxnHandler(w http.ResponseWriter, r *http.Request) {
+	var req txnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for i, op := range req.Ops {
+		if op.Collection == "" || op.Resource == "" {
+			http.Error(w, fmt.Sprintf("Operation %d: missing collection or resource name", i), http.StatusBadRequest)
+			return
+		}
+		if op.Op != "write" && op.Op != "delete" {
+			http.Error(w, fmt.Sprintf("Operation %d: unknown op %q (use write or delete)", i, op.Op), http.StatusBadRequest)
+			return
+		}
+		if op.Op == "write" && op.Data == nil {
+			http.Error(w, fmt.Sprintf("Operation %d: data must be a JSON object", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := database.Txn(func(tx *db.Tx) error {
+		for _, op := range req.Ops {
+			var err error
+			if op.Op == "write" {
+				err = tx.Write(op.Collection, op.Resource, op.Data)
+			} else {
+				err = tx.Delete(op.Collection, op.Resource)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func AggregateHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	var pipeline []map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := database.Aggregate(collection, pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+func LookupHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	opts := db.LookupOptions{
+		From:         r.URL.Query().Get("from"),
+		LocalField:   r.URL.Query().Get("localField"),
+		ForeignField: r.URL.Query().Get("foreignField"),
+		As:           r.URL.Query().Get("as"),
+	}
+	if opts.As == "" {
+		opts.As = opts.From
+	}
+
+	results, err := database.Lookup(collection, opts)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
+	if results == nil {
+		results = []map[string]interface{}{}
+	}
 
 	json.NewEncoder(w).Encode(results)
 }
+
+// queryBody decodes the optional query sent as the request body; an empty
+// body selects every record.
+func queryBody(r *http.Request) (map[string]interface{}, error) {
+	query := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return query, nil
+}
+
+func CountHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	query, err := queryBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := database.Count(collection, query)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]int{"count": n})
+}
+
+func DistinctHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	field := r.URL.Query().Get("field")
+	if collection == "" || field == "" {
+		http.Error(w, "Missing collection or field name", http.StatusBadRequest)
+		return
+	}
+
+	query, err := queryBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values, err := database.Distinct(collection, field, query)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(values)
+}
+
+func TextSearchHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	search := r.URL.Query().Get("search")
+	if collection == "" || search == "" {
+		http.Error(w, "Missing collection name or search text", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := queryBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	find, err := findOptionsParam(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(find.Sort) > 0 || find.Cursor != "" {
+		http.Error(w, "A text search is ranked by score and cannot be sorted or paged with a cursor", http.StatusBadRequest)
+		return
+	}
+
+	records, err := database.TextSearch(collection, search, &db.TextSearchOptions{
+		Filter:     filter,
+		Projection: find.Projection,
+		Skip:       find.Skip,
+		Limit:      find.Limit,
+		ScoreField: r.URL.Query().Get("score"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if records == nil {
+		records = []db.Record{}
+	}
+
+	json.NewEncoder(w).Encode(records)
+}
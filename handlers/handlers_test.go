@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+// newTestDB points the handlers at a database in a fresh temporary
+// directory, closed when the test ends.
+func newTestDB(t *testing.T) {
+	t.Helper()
+	InitDB(t.TempDir(), nil)
+	t.Cleanup(func() { database.Close() })
+}
+
+// serve calls h with a request and returns the recorded response. Header
+// names and values may follow the body in pairs.
+func serve(h http.HandlerFunc, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{&db.DuplicateKeyError{Collection: "c", Index: "email", Key: []interface{}{"x"}, Resource: "a"}, http.StatusConflict},
+		{fmt.Errorf("write: %w", &db.DuplicateKeyError{Collection: "c", Index: "email", Key: []interface{}{"x"}, Resource: "a"}), http.StatusConflict},
+		{fmt.Errorf("%w: c/r", db.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: c/r", db.ErrAlreadyExists), http.StatusConflict},
+		{fmt.Errorf("%w: empty", db.ErrInvalidUpdate), http.StatusBadRequest},
+		{fmt.Errorf("%w: unknown operator $x", db.ErrInvalidQuery), http.StatusBadRequest},
+		{fmt.Errorf("%w: c/r", db.ErrRevisionMismatch), http.StatusPreconditionFailed},
+		{fmt.Errorf("%w: unknown type thing", db.ErrInvalidSchema), http.StatusBadRequest},
+		{fmt.Errorf("%w: c/r has _id \"x\"", db.ErrInvalidID), http.StatusBadRequest},
+		{&db.ValidationError{Collection: "c", Resource: "r", Errors: []db.SchemaError{{Path: "n", Keyword: "type", Message: "expected integer, got string"}}}, http.StatusUnprocessableEntity},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.code {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestCreateResourceHandlerModes(t *testing.T) {
+	newTestDB(t)
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/write?collection=c&resource=r&mode=replace", http.StatusNotFound},
+		{"/write?collection=c&resource=r&mode=insert", http.StatusCreated},
+		{"/write?collection=c&resource=r&mode=insert", http.StatusConflict},
+		{"/write?collection=c&resource=r&mode=replace", http.StatusCreated},
+		{"/write?collection=c&resource=r&mode=upsert", http.StatusCreated},
+		{"/write?collection=c&resource=r", http.StatusCreated},
+		{"/write?collection=c&resource=r&mode=merge", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := serve(CreateResourceHandler, http.MethodPost, tt.target, `{"n": 1}`); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, rec.Code, tt.code, rec.Body)
+		}
+	}
+}
+
+func TestCreateResourceHandlerBody(t *testing.T) {
+	newTestDB(t)
+	tests := []struct {
+		name string
+		body string
+		code int
+		want string // the document read back
+	}{
+		{"array", `[1]`, http.StatusBadRequest, ""},
+		{"not JSON", `{"n": `, http.StatusBadRequest, ""},
+		{"another _id", `{"_id": "s", "n": 1}`, http.StatusBadRequest, ""},
+		{"its own _id", `{"_id": "r", "n": 1}`, http.StatusCreated, `{"_id":"r","n":1}`},
+		{"exact numbers", `{"big": 9007199254740993, "n": 1.50}`, http.StatusCreated, `{"_id":"r","big":9007199254740993,"n":1.50}`},
+	}
+	for _, tt := range tests {
+		rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		read := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "")
+		if got := strings.TrimSpace(read.Body.String()); got != tt.want {
+			t.Errorf("%s: read %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateResourceHandler(t *testing.T) {
+	newTestDB(t)
+	if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", `{"n": 1}`); rec.Code != http.StatusCreated {
+		t.Fatalf("write: status %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"post", http.MethodPost, "/update?collection=c&resource=r", `{"$inc": {"n": 1}}`, http.StatusMethodNotAllowed},
+		{"put", http.MethodPut, "/update?collection=c&resource=r", `{"$inc": {"n": 1}}`, http.StatusMethodNotAllowed},
+		{"missing resource name", http.MethodPatch, "/update?collection=c", `{"$inc": {"n": 1}}`, http.StatusBadRequest},
+		{"malformed body", http.MethodPatch, "/update?collection=c&resource=r", `{`, http.StatusBadRequest},
+		{"invalid update", http.MethodPatch, "/update?collection=c&resource=r", `{"$frob": {"n": 1}}`, http.StatusBadRequest},
+		{"unknown resource", http.MethodPatch, "/update?collection=c&resource=x", `{"$inc": {"n": 1}}`, http.StatusNotFound},
+		{"update", http.MethodPatch, "/update?collection=c&resource=r", `{"$inc": {"n": 1}}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serve(UpdateResourceHandler, tt.method, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "")
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"_id":"r","n":2}` {
+		t.Errorf("read after updates: %s, want {\"_id\":\"r\",\"n\":2}", got)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	newTestDB(t)
+	const target = "/write?collection=c&resource=r"
+
+	// Each step sees the ETag returned by the previous successful write.
+	etag := ""
+	tests := []struct {
+		name   string
+		target string
+		header []string
+		code   int
+	}{
+		{"if-match on a missing resource", target, []string{"If-Match", "*"}, http.StatusPreconditionFailed},
+		{"replace with a precondition", target + "&mode=replace", []string{"If-None-Match", "*"}, http.StatusNotFound},
+		{"create", target, []string{"If-None-Match", "*"}, http.StatusCreated},
+		{"create again", target, []string{"If-None-Match", "*"}, http.StatusPreconditionFailed},
+		{"insert with a precondition", target + "&mode=insert", []string{"If-Match", "*"}, http.StatusConflict},
+		{"stale etag", target, []string{"If-Match", `"0123456789abcdef"`}, http.StatusPreconditionFailed},
+		{"current etag", target, []string{"If-Match", "current"}, http.StatusCreated},
+		{"any etag", target + "&mode=replace", []string{"If-Match", "*"}, http.StatusCreated},
+	}
+	for i, tt := range tests {
+		if tt.header[1] == "current" {
+			tt.header[1] = etag
+		}
+		rec := serve(CreateResourceHandler, http.MethodPost, tt.target, fmt.Sprintf(`{"n": %d}`, i), tt.header...)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+		if rec.Code == http.StatusCreated {
+			if etag = rec.Header().Get("ETag"); etag == "" {
+				t.Errorf("%s: no ETag", tt.name)
+			}
+		}
+	}
+
+	rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Errorf("read: status %d, ETag %s, want 200 and %s", rec.Code, rec.Header().Get("ETag"), etag)
+	}
+	if rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("read with the current ETag: status %d, want 304", rec.Code)
+	}
+	if rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "", "If-Match", `"0123456789abcdef"`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("read with a stale If-Match: status %d, want 412", rec.Code)
+	}
+}
+
+func TestTxnHandler(t *testing.T) {
+	newTestDB(t)
+	if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=pending&resource=42", `{"item": "book"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("write: status %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"malformed body", `{"ops": [`, http.StatusBadRequest},
+		{"missing resource name", `{"ops": [{"op": "delete", "collection": "pending"}]}`, http.StatusBadRequest},
+		{"unknown op", `{"ops": [{"op": "move", "collection": "pending", "resource": "42"}]}`, http.StatusBadRequest},
+		{"write without data", `{"ops": [{"op": "write", "collection": "done", "resource": "42"}]}`, http.StatusBadRequest},
+		{"write with null data", `{"ops": [{"op": "write", "collection": "done", "resource": "42", "data": null}]}`, http.StatusBadRequest},
+		{"write with an array", `{"ops": [{"op": "write", "collection": "done", "resource": "42", "data": [1]}]}`, http.StatusBadRequest},
+		{"delete of a missing resource", `{"ops": [
+			{"op": "write", "collection": "done", "resource": "42", "data": {"item": "book"}},
+			{"op": "delete", "collection": "pending", "resource": "43"}]}`, http.StatusNotFound},
+		{"move", `{"ops": [
+			{"op": "write", "collection": "done", "resource": "42", "data": {"item": "book"}},
+			{"op": "delete", "collection": "pending", "resource": "42"}]}`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if rec := serve(TxnHandler, http.MethodPost, "/txn", tt.body); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	if rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=done&resource=42", ""); rec.Code != http.StatusOK {
+		t.Errorf("read moved resource: status %d", rec.Code)
+	}
+	if rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=pending&resource=42", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("read removed resource: status %d", rec.Code)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	newTestDB(t)
+	for _, target := range []string{
+		"/write?collection=a&resource=1",
+		"/write?collection=b&resource=1",
+		"/write?collection=c&resource=1",
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, target, `{"n": 1}`); rec.Code != http.StatusCreated {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		target string
+		body   string
+		code   int
+		want   string
+	}{
+		{"/search", `{"n": 1}`, http.StatusOK, `{"a":["1"],"b":["1"],"c":["1"]}`},
+		{"/search?collection=a&collection=c", `{"n": 1}`, http.StatusOK, `{"a":["1"],"c":["1"]}`},
+		{"/search?collection=b,%20c", `{"n": 1}`, http.StatusOK, `{"b":["1"],"c":["1"]}`},
+		{"/search?collection=a&documents=true", `{"n": 1}`, http.StatusOK, `[{"collection":"a","resource":"1","document":{"_id":"1","n":1}}]`},
+		{"/search?collection=a&documents=true", `{"n": 2}`, http.StatusOK, `[]`},
+		{"/search", `{"n": `, http.StatusBadRequest, ""},
+		{"/search", `{"n": {"$bad": 1}}`, http.StatusBadRequest, ""},
+		{"/search", `{"$or": [{"n": 2}, {"n": {"$lt": 2}}]}`, http.StatusOK, `{"a":["1"],"b":["1"],"c":["1"]}`},
+	}
+	for _, tt := range tests {
+		rec := serve(SearchHandler, http.MethodPost, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.target, tt.body, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.want != "" && strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s %s: body %s, want %s", tt.target, tt.body, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestSearchHandlerExplain(t *testing.T) {
+	newTestDB(t)
+	for _, target := range []string{"/write?collection=a&resource=1", "/write?collection=a&resource=2"} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, target, `{"n": 1}`); rec.Code != http.StatusCreated {
+			t.Fatalf("%s: status %d: %s", target, rec.Code, rec.Body)
+		}
+	}
+
+	rec := serve(SearchHandler, http.MethodPost, "/search?collection=a&explain=true", `{"n": 1}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var ex db.Explain
+	if err := json.Unmarshal(rec.Body.Bytes(), &ex); err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Collections) != 1 || ex.Collections[0].Plan != "COLLSCAN" || ex.FilesExamined != 2 || ex.DocsReturned != 2 {
+		t.Errorf("explain %s", rec.Body)
+	}
+}
+
+func TestFindOptionsParams(t *testing.T) {
+	newTestDB(t)
+	for _, doc := range []struct{ name, body string }{
+		{"a", `{"name": "ann", "age": 30}`},
+		{"b", `{"name": "bob", "age": 25}`},
+		{"c", `{"name": "cat", "age": 35}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=people&resource="+doc.name, doc.body); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", doc.name, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		h      http.HandlerFunc
+		target string
+		code   int
+		want   string
+	}{
+		{ReadAllResourcesHandler, "/readall?collection=people&sort=-age&projection=name", http.StatusOK, `[{"_id":"c","name":"cat"},{"_id":"a","name":"ann"},{"_id":"b","name":"bob"}]`},
+		{ReadAllResourcesHandler, "/readall?collection=people&sort=age&projection=-age&skip=1&limit=1", http.StatusOK, `[{"_id":"a","name":"ann"}]`},
+		{ReadAllResourcesHandler, "/readall?collection=people&projection=name,-age", http.StatusBadRequest, ""},
+		{ReadAllResourcesHandler, "/readall?collection=people&limit=ten", http.StatusBadRequest, ""},
+		{ReadAllResourcesHandler, "/readall?collection=people&skip=-1", http.StatusBadRequest, ""},
+		{SearchHandler, "/search?documents=true&sort=name&projection=name&limit=2", http.StatusOK,
+			`[{"collection":"people","resource":"a","document":{"_id":"a","name":"ann"}},{"collection":"people","resource":"b","document":{"_id":"b","name":"bob"}}]`},
+		{SearchHandler, "/search?sort=-name&limit=1", http.StatusOK, `{"people":["c"]}`},
+		{SearchHandler, "/search?limit=-1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.h, http.MethodGet, tt.target, `{}`)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.want != "" && strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s: body %s, want %s", tt.target, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestCursorParams(t *testing.T) {
+	newTestDB(t)
+	for _, name := range []string{"a", "b", "c"} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource="+name, `{"name": "`+name+`"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	for _, h := range []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		want    []string
+	}{
+		{"readall", ReadAllResourcesHandler, "/readall?collection=c&page_size=2", []string{
+			`[{"_id":"a","name":"a"},{"_id":"b","name":"b"}]`, `[{"_id":"c","name":"c"}]`,
+		}},
+		{"search", SearchHandler, "/search?collection=c&page_size=2", []string{
+			`{"c":["a","b"]}`, `{"c":["c"]}`,
+		}},
+	} {
+		target := h.target
+		for i, want := range h.want {
+			rec := serve(h.handler, http.MethodGet, target, `{}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s page %d: status %d: %s", h.name, i, rec.Code, rec.Body)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != want {
+				t.Errorf("%s page %d: body %s, want %s", h.name, i, got, want)
+			}
+			cursor := rec.Header().Get("X-Next-Cursor")
+			if last := i == len(h.want)-1; last != (cursor == "") {
+				t.Fatalf("%s page %d: X-Next-Cursor %q", h.name, i, cursor)
+			}
+			target = h.target + "&cursor=" + cursor
+		}
+	}
+
+	for _, target := range []string{
+		"/readall?collection=c&cursor=!",
+		"/readall?collection=c&cursor=e30&limit=1",
+		"/readall?collection=c&page_size=x",
+	} {
+		if rec := serve(ReadAllResourcesHandler, http.MethodGet, target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", target, rec.Code)
+		}
+	}
+	rec := serve(ReadAllResourcesHandler, http.MethodGet, "/readall?collection=c&limit=1", "")
+	if rec := serve(SearchHandler, http.MethodGet, "/search?sort=name&cursor="+rec.Header().Get("X-Next-Cursor"), `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("cursor with sort: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAggregateHandler(t *testing.T) {
+	newTestDB(t)
+	for _, doc := range []struct{ name, body string }{
+		{"1", `{"category": "books", "amount": 10}`},
+		{"2", `{"category": "books", "amount": 30}`},
+		{"3", `{"category": "games", "amount": 20}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=orders&resource="+doc.name, doc.body); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", doc.name, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		target string
+		body   string
+		code   int
+		want   string
+	}{
+		{"/aggregate?collection=orders", `[{"$group": {"_id": "$category", "total": {"$sum": "$amount"}}}, {"$sort": {"total": -1}}]`,
+			http.StatusOK, `[{"_id":"books","total":40},{"_id":"games","total":20}]`},
+		{"/aggregate?collection=orders", `[{"$match": {"amount": {"$gt": 100}}}]`, http.StatusOK, `[]`},
+		{"/aggregate?collection=missing", `[]`, http.StatusOK, `[]`},
+		{"/aggregate?collection=orders", `[{"$out": "x"}]`, http.StatusBadRequest, ""},
+		{"/aggregate?collection=orders", `{"$match": {}}`, http.StatusBadRequest, ""},
+		{"/aggregate", `[]`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(AggregateHandler, http.MethodPost, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.target, tt.body, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.want != "" && strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s %s: body %s, want %s", tt.target, tt.body, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestRegexSearchHandlerRejectsBadPatterns(t *testing.T) {
+	newTestDB(t)
+	if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", `{"name": "x"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("write: status %d: %s", rec.Code, rec.Body)
+	}
+	for _, accept := range []string{"application/json", "application/x-ndjson"} {
+		if rec := serve(RegexSearchHandler, http.MethodPost, "/regexsearch?collection=c", `{"name": "["}`, "Accept", accept); rec.Code != http.StatusBadRequest {
+			t.Errorf("Accept %s: status %d, want 400: %s", accept, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestLookupHandler(t *testing.T) {
+	newTestDB(t)
+	for _, w := range []struct{ target, body string }{
+		{"/write?collection=customers&resource=c1", `{"name": "ann"}`},
+		{"/write?collection=orders&resource=o1", `{"customer": "c1"}`},
+		{"/write?collection=orders&resource=o2", `{"customer": "c2"}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, w.target, w.body); rec.Code != http.StatusCreated {
+			t.Fatalf("%s: status %d: %s", w.target, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		target string
+		code   int
+		want   string
+	}{
+		{"/lookup?collection=orders&from=customers&localField=customer&foreignField=_id", http.StatusOK,
+			`[{"_id":"o1","customer":"c1","customers":[{"_id":"c1","name":"ann"}]},{"_id":"o2","customer":"c2","customers":[]}]`},
+		{"/lookup?collection=orders&from=customers&localField=customer&foreignField=_id&as=c", http.StatusOK,
+			`[{"_id":"o1","c":[{"_id":"c1","name":"ann"}],"customer":"c1"},{"_id":"o2","c":[],"customer":"c2"}]`},
+		{"/lookup?collection=missing&from=customers&localField=customer&foreignField=_id", http.StatusOK, `[]`},
+		{"/lookup?collection=orders&from=customers&localField=customer", http.StatusBadRequest, ""},
+		{"/lookup?from=customers&localField=customer&foreignField=_id", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(LookupHandler, http.MethodGet, tt.target, "")
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.want != "" && strings.TrimSpace(rec.Body.String()) != tt.want {
+			t.Errorf("%s: body %s, want %s", tt.target, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestCountDistinctAndHead(t *testing.T) {
+	newTestDB(t)
+	for _, w := range []struct{ name, body string }{
+		{"1", `{"city": "Austin", "active": true}`},
+		{"2", `{"city": "Boston", "active": false}`},
+		{"3", `{"city": "Austin", "active": true}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=users&resource="+w.name, w.body); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", w.name, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		h      http.HandlerFunc
+		method string
+		target string
+		body   string
+		code   int
+		want   string
+	}{
+		{CountHandler, http.MethodPost, "/count?collection=users", "", http.StatusOK, `{"count":3}`},
+		{CountHandler, http.MethodPost, "/count?collection=users", `{"active": true}`, http.StatusOK, `{"count":2}`},
+		{CountHandler, http.MethodPost, "/count?collection=missing", "", http.StatusOK, `{"count":0}`},
+		{CountHandler, http.MethodPost, "/count?collection=users", `{"active": {"$bad": 1}}`, http.StatusBadRequest, ""},
+		{CountHandler, http.MethodPost, "/count?collection=users", `{"active"`, http.StatusBadRequest, ""},
+		{CountHandler, http.MethodPost, "/count", "", http.StatusBadRequest, ""},
+		{DistinctHandler, http.MethodPost, "/distinct?collection=users&field=city", "", http.StatusOK, `["Austin","Boston"]`},
+		{DistinctHandler, http.MethodPost, "/distinct?collection=users&field=city", `{"active": false}`, http.StatusOK, `["Boston"]`},
+		{DistinctHandler, http.MethodPost, "/distinct?collection=users", "", http.StatusBadRequest, ""},
+		{ReadResourceHandler, http.MethodHead, "/read?collection=users&resource=1", "", http.StatusOK, ""},
+		{ReadResourceHandler, http.MethodHead, "/read?collection=users&resource=9", "", http.StatusNotFound, ""},
+		{ReadResourceHandler, http.MethodHead, "/read?collection=missing&resource=1", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.h, tt.method, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s %s: status %d, want %d: %s", tt.method, tt.target, tt.body, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.code == http.StatusOK && got != tt.want {
+			t.Errorf("%s %s %s: body %q, want %q", tt.method, tt.target, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestTextSearchHandler(t *testing.T) {
+	newTestDB(t)
+	for _, w := range []struct{ name, body string }{
+		{"1", `{"title": "Running shoes", "price": 80}`},
+		{"2", `{"title": "Trail runs", "price": 120}`},
+		{"3", `{"title": "Tea", "price": 4}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=posts&resource="+w.name, w.body); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", w.name, rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(TextSearchHandler, http.MethodPost, "/textsearch?collection=posts&search=run", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("search without a text index: status %d, want 400", rec.Code)
+	}
+	if rec := serve(CreateIndexHandler, http.MethodPost, "/createindex?collection=posts&field=title&text=true", ""); rec.Code != http.StatusCreated {
+		t.Fatalf("createindex: status %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		h      http.HandlerFunc
+		target string
+		body   string
+		code   int
+		want   string
+	}{
+		{TextSearchHandler, "/textsearch?collection=posts&search=run&projection=price", "", http.StatusOK,
+			`[{"collection":"posts","resource":"1","document":{"_id":"1","price":80}},{"collection":"posts","resource":"2","document":{"_id":"2","price":120}}]`},
+		{TextSearchHandler, "/textsearch?collection=posts&search=run&projection=price", `{"price": {"$gt": 100}}`, http.StatusOK,
+			`[{"collection":"posts","resource":"2","document":{"_id":"2","price":120}}]`},
+		{TextSearchHandler, "/textsearch?collection=posts&search=coffee", "", http.StatusOK, `[]`},
+		{TextSearchHandler, "/textsearch?collection=posts", "", http.StatusBadRequest, ""},
+		{TextSearchHandler, "/textsearch?collection=posts&search=run&sort=price", "", http.StatusBadRequest, ""},
+		{TextSearchHandler, "/textsearch?collection=posts&search=run", `{"price": {"$bad": 1}}`, http.StatusBadRequest, ""},
+		{SearchHandler, "/search", `{"$text": {"$search": "tea shoes"}, "price": {"$lt": 50}}`, http.StatusOK, `{"posts":["3"]}`},
+		{CountHandler, "/count?collection=posts", `{"$text": {"$search": "run -trail"}}`, http.StatusOK, `{"count":1}`},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.h, http.MethodPost, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.target, tt.body, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.code == http.StatusOK && got != tt.want {
+			t.Errorf("%s %s: body %s, want %s", tt.target, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestInsertHandler(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+		id     string // the expected _id, or "generated"
+	}{
+		{"get", http.MethodGet, "/insert?collection=c", `{}`, http.StatusMethodNotAllowed, ""},
+		{"missing collection", http.MethodPost, "/insert", `{}`, http.StatusBadRequest, ""},
+		{"array body", http.MethodPost, "/insert?collection=c", `[1]`, http.StatusBadRequest, ""},
+		{"number _id", http.MethodPost, "/insert?collection=c", `{"_id": 1}`, http.StatusBadRequest, ""},
+		{"resource and _id differ", http.MethodPost, "/insert?collection=c&resource=a", `{"_id": "b"}`, http.StatusBadRequest, ""},
+		{"generated", http.MethodPost, "/insert?collection=c", `{"n": 1}`, http.StatusCreated, "generated"},
+		{"own _id", http.MethodPost, "/insert?collection=c", `{"_id": "mine"}`, http.StatusCreated, "mine"},
+		{"taken _id", http.MethodPost, "/insert?collection=c", `{"_id": "mine"}`, http.StatusConflict, ""},
+		{"resource", http.MethodPost, "/insert?collection=c&resource=a%20b", `{"n": 1}`, http.StatusCreated, "a b"},
+		{"resource and the same _id", http.MethodPost, "/insert?collection=c&resource=d", `{"_id": "d"}`, http.StatusCreated, "d"},
+		{"taken resource", http.MethodPost, "/insert?collection=c&resource=d", `{"n": 1}`, http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(InsertHandler, tt.method, tt.target, tt.body)
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if tt.id == "" {
+			continue
+		}
+		var body struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.id == "generated" && len(body.ID) != 24 || tt.id != "generated" && body.ID != tt.id {
+			t.Errorf("%s: _id %q, want %s", tt.name, body.ID, tt.id)
+		}
+		location := rec.Header().Get("Location")
+		read := serve(ReadResourceHandler, http.MethodGet, location, "")
+		var doc map[string]interface{}
+		if err := json.Unmarshal(read.Body.Bytes(), &doc); err != nil || doc["_id"] != body.ID {
+			t.Errorf("%s: reading %s: %s", tt.name, location, read.Body)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+func CreateIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	field := r.URL.Query().Get("field")
+	if collection == "" || field == "" {
+		http.Error(w, "Missing collection or field name", http.StatusBadRequest)
+		return
+	}
+
+	opts := &db.IndexOptions{Name: r.URL.Query().Get("name")}
+	for param, flag := range map[string]*bool{"unique": &opts.Unique, "sparse": &opts.Sparse, "text": &opts.Text} {
+		if v := r.URL.Query().Get(param); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s flag %q", param, v), http.StatusBadRequest)
+				return
+			}
+			*flag = b
+		}
+	}
+
+	spec, err := database.CreateIndex(collection, field, opts)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(spec)
+}
+
+func ListIndexesHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(database.ListIndexes(collection))
+}
+
+func DropIndexHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	name := r.URL.Query().Get("name")
+	if collection == "" || name == "" {
+		http.Error(w, "Missing collection or index name", http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DropIndex(collection, name); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+func TestIndexHandlers(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		code    int
+	}{
+		{"create without field", CreateIndexHandler, http.MethodPost, "/createindex?collection=c", http.StatusBadRequest},
+		{"create", CreateIndexHandler, http.MethodPost, "/createindex?collection=c&field=a.b,c", http.StatusCreated},
+		{"create named", CreateIndexHandler, http.MethodPost, "/createindex?collection=c&field=d&name=by_d", http.StatusCreated},
+		{"create with a bad flag", CreateIndexHandler, http.MethodPost, "/createindex?collection=c&field=e&unique=maybe", http.StatusBadRequest},
+		{"create unique", CreateIndexHandler, http.MethodPost, "/createindex?collection=u&field=email&unique=true", http.StatusCreated},
+		{"drop without name", DropIndexHandler, http.MethodDelete, "/dropindex?collection=c", http.StatusBadRequest},
+		{"drop", DropIndexHandler, http.MethodDelete, "/dropindex?collection=c&name=by_d", http.StatusNoContent},
+		{"drop again", DropIndexHandler, http.MethodDelete, "/dropindex?collection=c&name=by_d", http.StatusNotFound},
+		{"list without collection", ListIndexesHandler, http.MethodGet, "/listindexes", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := serve(tt.handler, tt.method, tt.target, ""); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	for i, code := range []int{http.StatusCreated, http.StatusConflict} {
+		rec := serve(CreateResourceHandler, http.MethodPost, fmt.Sprintf("/write?collection=u&resource=%d", i), `{"email": "x"}`)
+		if rec.Code != code {
+			t.Errorf("write %d with a taken key: status %d, want %d: %s", i, rec.Code, code, rec.Body)
+		}
+	}
+
+	rec := serve(ListIndexesHandler, http.MethodGet, "/listindexes?collection=c", "")
+	var specs []db.IndexSpec
+	if err := json.NewDecoder(rec.Body).Decode(&specs); err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 || specs[0].Name != "a.b_c" {
+		t.Errorf("indexes = %+v, want only a.b_c", specs)
+	}
+}
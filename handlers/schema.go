@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+// SchemaHandler shows (GET), sets (POST) or removes (DELETE) the JSON
+// Schema of a collection. POST takes the schema as its body and the
+// validation level as the level parameter.
+func SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		v := database.Validator(collection)
+		if v == nil {
+			http.Error(w, fmt.Sprintf("No schema on %s", collection), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+
+	case http.MethodPost:
+		v := &db.Validator{Level: db.ValidationLevel(r.URL.Query().Get("level"))}
+		if err := json.NewDecoder(r.Body).Decode(&v.Schema); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := database.SetValidator(collection, v); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(database.Validator(collection))
+
+	case http.MethodDelete:
+		if err := database.SetValidator(collection, nil); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSchemaHandler(t *testing.T) {
+	newTestDB(t)
+	const schema = `{"required": ["n"], "properties": {"n": {"type": "integer", "minimum": 0}}}`
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"get without collection", http.MethodGet, "/schema", "", http.StatusBadRequest},
+		{"get unset", http.MethodGet, "/schema?collection=c", "", http.StatusNotFound},
+		{"set a malformed body", http.MethodPost, "/schema?collection=c", `{`, http.StatusBadRequest},
+		{"set an invalid schema", http.MethodPost, "/schema?collection=c", `{"type": "thing"}`, http.StatusBadRequest},
+		{"set an unknown level", http.MethodPost, "/schema?collection=c&level=lenient", schema, http.StatusBadRequest},
+		{"set", http.MethodPost, "/schema?collection=c", schema, http.StatusOK},
+		{"get", http.MethodGet, "/schema?collection=c", "", http.StatusOK},
+		{"put", http.MethodPut, "/schema?collection=c", schema, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rec := serve(SchemaHandler, tt.method, tt.target, tt.body); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+	rec := serve(SchemaHandler, http.MethodGet, "/schema?collection=c", "")
+	if got := strings.TrimSpace(rec.Body.String()); !strings.Contains(got, `"level":"strict"`) {
+		t.Errorf("get: %s, want the strict level", got)
+	}
+
+	// Every kind of write reports the violations as JSON.
+	if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=ok", `{"n": 1}`); rec.Code != http.StatusCreated {
+		t.Fatalf("write: status %d: %s", rec.Code, rec.Body)
+	}
+	for _, w := range []struct {
+		h      http.HandlerFunc
+		method string
+		target string
+		body   string
+	}{
+		{CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", `{"n": -1, "m": 1}`},
+		{CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", `{}`},
+		{UpdateResourceHandler, http.MethodPatch, "/update?collection=c&resource=ok", `{"$set": {"n": "x"}}`},
+		{TxnHandler, http.MethodPost, "/txn", `{"ops": [{"op": "write", "collection": "c", "resource": "r", "data": {"n": 1.5}}]}`},
+	} {
+		rec := serve(w.h, w.method, w.target, w.body)
+		if rec.Code != http.StatusUnprocessableEntity || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: status %d, type %q: %s", w.target, w.body, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			continue
+		}
+		var body struct {
+			Error  string
+			Errors []struct{ Path, Keyword, Message string }
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" || len(body.Errors) != 1 || body.Errors[0].Path != "n" {
+			t.Errorf("%s %s: body %s", w.target, w.body, rec.Body)
+		}
+	}
+
+	if rec := serve(SchemaHandler, http.MethodDelete, "/schema?collection=c", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=c&resource=r", `{}`); rec.Code != http.StatusCreated {
+		t.Errorf("write without a schema: status %d: %s", rec.Code, rec.Body)
+	}
+}
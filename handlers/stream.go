@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const ndjsonType = "application/x-ndjson"
+
+// wantsNDJSON reports whether the client asked for newline-delimited JSON.
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonType)
+}
+
+// ndjsonWriter writes one JSON document per line and flushes each line, so
+// that the client receives records while the server is still reading them.
+type ndjsonWriter struct {
+	w       http.ResponseWriter
+	started bool
+	buf     bytes.Buffer
+}
+
+// write sends a document given as encoded JSON.
+func (s *ndjsonWriter) write(doc []byte) error {
+	if !s.started {
+		s.w.Header().Set("Content-Type", ndjsonType)
+		s.started = true
+	}
+
+	s.buf.Reset()
+	if err := json.Compact(&s.buf, doc); err != nil {
+		return err
+	}
+	s.buf.WriteByte('\n')
+	if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// encode sends v as a document.
+func (s *ndjsonWriter) encode(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// finish reports an error that ended the stream. Before the first line the
+// response can still carry an error status; afterwards the stream is cut
+// short and the error is only logged.
+func (s *ndjsonWriter) finish(err error) {
+	if err == nil {
+		if !s.started {
+			s.w.Header().Set("Content-Type", ndjsonType)
+		}
+		return
+	}
+	if !s.started {
+		http.Error(s.w, err.Error(), errorStatus(err))
+		return
+	}
+	log.Println("Streaming response aborted:", err)
+}
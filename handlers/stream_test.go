@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNDJSON(t *testing.T) {
+	newTestDB(t)
+	for _, doc := range []struct{ name, body string }{
+		{"a", `{"name": "ann", "age": 30}`},
+		{"b", `{"name": "bob", "age": 25}`},
+		{"c", `{"name": "cat", "age": 35}`},
+	} {
+		if rec := serve(CreateResourceHandler, http.MethodPost, "/write?collection=people&resource="+doc.name, doc.body); rec.Code != http.StatusCreated {
+			t.Fatalf("write %s: status %d: %s", doc.name, rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		h       http.HandlerFunc
+		target  string
+		body    string
+		ordered bool
+		want    []string
+	}{
+		{"readall", ReadAllResourcesHandler, "/readall?collection=people", "", false, []string{
+			`{"_id":"a","name":"ann","age":30}`, `{"_id":"b","name":"bob","age":25}`, `{"_id":"c","name":"cat","age":35}`,
+		}},
+		{"readall with options", ReadAllResourcesHandler, "/readall?collection=people&sort=-age&projection=name&limit=2", "", true, []string{
+			`{"_id":"c","name":"cat"}`, `{"_id":"a","name":"ann"}`,
+		}},
+		{"regexsearch", RegexSearchHandler, "/regexsearch?collection=people", `{"name": "^(ann|cat)$"}`, false, []string{
+			`{"_id":"a","age":30,"name":"ann"}`, `{"_id":"c","age":35,"name":"cat"}`,
+		}},
+		{"empty result", RegexSearchHandler, "/regexsearch?collection=people", `{"name": "^dan$"}`, false, nil},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.h, http.MethodPost, tt.target, tt.body, "Accept", "application/x-ndjson")
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tt.name, rec.Code, rec.Body)
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("%s: Content-Type %q", tt.name, ct)
+		}
+		var got []string
+		if body := rec.Body.String(); body != "" {
+			if !strings.HasSuffix(body, "\n") {
+				t.Errorf("%s: last line not terminated: %q", tt.name, body)
+			}
+			got = strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+		}
+		if !tt.ordered {
+			sort.Strings(got)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: lines %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	rec := serve(ReadAllResourcesHandler, http.MethodGet, "/readall?collection=missing", "", "Accept", "application/x-ndjson")
+	if rec.Code == http.StatusOK {
+		t.Errorf("missing collection: status 200: %s", rec.Body)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Sakthe-Balan/GoMongoDB/db"
+)
+
+// TTLHandler shows (GET), sets (POST) or removes (DELETE) the TTL of a
+// collection.
+func TTLHandler(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Missing collection name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ttl := database.TTL(collection)
+		if ttl == nil {
+			http.Error(w, fmt.Sprintf("No TTL on %s", collection), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(ttl)
+
+	case http.MethodPost:
+		ttl := &db.TTLSpec{Field: r.URL.Query().Get("field")}
+		if v := r.URL.Query().Get("expireAfterSeconds"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid expireAfterSeconds %q", v), http.StatusBadRequest)
+				return
+			}
+			ttl.ExpireAfterSeconds = n
+		}
+		if ttl.Field == "" {
+			http.Error(w, "Missing field name", http.StatusBadRequest)
+			return
+		}
+		if err := database.SetTTL(collection, ttl); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(ttl)
+
+	case http.MethodDelete:
+		if err := database.SetTTL(collection, nil); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTTLHandler(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+		want   string
+	}{
+		{"get without collection", http.MethodGet, "/ttl", http.StatusBadRequest, ""},
+		{"get unset", http.MethodGet, "/ttl?collection=s", http.StatusNotFound, ""},
+		{"set without field", http.MethodPost, "/ttl?collection=s", http.StatusBadRequest, ""},
+		{"set with bad seconds", http.MethodPost, "/ttl?collection=s&field=at&expireAfterSeconds=-1", http.StatusBadRequest, ""},
+		{"set", http.MethodPost, "/ttl?collection=s&field=at&expireAfterSeconds=60", http.StatusOK, `{"field":"at","expireAfterSeconds":60}`},
+		{"get", http.MethodGet, "/ttl?collection=s", http.StatusOK, `{"field":"at","expireAfterSeconds":60}`},
+		{"delete", http.MethodDelete, "/ttl?collection=s", http.StatusNoContent, ""},
+		{"get deleted", http.MethodGet, "/ttl?collection=s", http.StatusNotFound, ""},
+		{"put", http.MethodPut, "/ttl?collection=s", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		rec := serve(TTLHandler, tt.method, tt.target, "")
+		if rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); tt.want != "" && got != tt.want {
+			t.Errorf("%s: body %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteWithTTL(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name   string
+		target string
+		header []string
+		code   int
+	}{
+		{"bad ttl", "/write?collection=c&resource=r&ttl=soon", nil, http.StatusBadRequest},
+		{"negative ttl", "/write?collection=c&resource=r&ttl=-1m", nil, http.StatusBadRequest},
+		{"ttl with a precondition", "/write?collection=c&resource=r&ttl=1h", []string{"If-None-Match", "*"}, http.StatusBadRequest},
+		{"ttl", "/write?collection=c&resource=r&ttl=1h", nil, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if rec := serve(CreateResourceHandler, http.MethodPost, tt.target, `{"n": 1}`, tt.header...); rec.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.code, rec.Body)
+		}
+	}
+
+	rec := serve(ReadResourceHandler, http.MethodGet, "/read?collection=c&resource=r", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"_expires"`) {
+		t.Errorf("read: status %d: %s", rec.Code, rec.Body)
+	}
+}
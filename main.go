@@ -1,23 +1,42 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 
+	"github.com/Sakthe-Balan/GoMongoDB/db"
 	"github.com/Sakthe-Balan/GoMongoDB/handlers"
 )
 
 func main() {
+	metadata := flag.Bool("metadata", false, "maintain _created, _updated and _rev in every document")
+	flag.Parse()
+
 	dir := "./dbase"
-	handlers.InitDB(dir)
+	handlers.InitDB(dir, &db.Options{Metadata: *metadata})
 
 	http.HandleFunc("/write", handlers.CreateResourceHandler)     // POST
-	http.HandleFunc("/read", handlers.ReadResourceHandler)        // GET
+	http.HandleFunc("/insert", handlers.InsertHandler)            // POST
+	http.HandleFunc("/read", handlers.ReadResourceHandler)        // GET, HEAD
+	http.HandleFunc("/update", handlers.UpdateResourceHandler)    // PATCH
 	http.HandleFunc("/readall", handlers.ReadAllResourcesHandler) // GET
 	http.HandleFunc("/delete", handlers.DeleteResourceHandler)    // DELETE
 	http.HandleFunc("/deleteall", handlers.DeleteAllHandler)      // DELETE
 	http.HandleFunc("/search", handlers.SearchHandler)            // POST
 	http.HandleFunc("/regexsearch", handlers.RegexSearchHandler)
+	http.HandleFunc("/txn", handlers.TxnHandler)                 // POST
+	http.HandleFunc("/createindex", handlers.CreateIndexHandler) // POST
+	http.HandleFunc("/listindexes", handlers.ListIndexesHandler) // GET
+	http.HandleFunc("/dropindex", handlers.DropIndexHandler)     // DELETE
+	http.HandleFunc("/aggregate", handlers.AggregateHandler)     // POST
+	http.HandleFunc("/lookup", handlers.LookupHandler)           // GET
+	http.HandleFunc("/count", handlers.CountHandler)             // POST
+	http.HandleFunc("/distinct", handlers.DistinctHandler)       // POST
+	http.HandleFunc("/textsearch", handlers.TextSearchHandler)   // POST
+	http.HandleFunc("/ttl", handlers.TTLHandler)                 // GET, POST, DELETE
+	http.HandleFunc("/capped", handlers.CappedHandler)           // GET, POST, DELETE
+	http.HandleFunc("/schema", handlers.SchemaHandler)           // GET, POST, DELETE
 
 	fmt.Println("Starting server on :6942")
 	if err := http.ListenAndServe(":6942", nil); err != nil {